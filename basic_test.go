@@ -173,7 +173,7 @@ func TestBasic(t *testing.T) {
 func TestUnit(t *testing.T) {
 	expectHash := func(tree *ImmutableTree, hashCount int64) {
 		// ensure number of new hash calculations is as expected.
-		hash, count, err := tree.root.hashWithCount()
+		hash, count, err := tree.root.hashWithCount(nil)
 		require.NoError(t, err)
 		if count != hashCount {
 			t.Fatalf("Expected %v new hashes, got %v", hashCount, count)
@@ -184,7 +184,7 @@ func TestUnit(t *testing.T) {
 			return false
 		})
 		// ensure that the new hash after nuking is the same as the old.
-		newHash, _, err := tree.root.hashWithCount()
+		newHash, _, err := tree.root.hashWithCount(nil)
 		require.NoError(t, err)
 		if !bytes.Equal(hash, newHash) {
 			t.Fatalf("Expected hash %v but got %v after nuking", hash, newHash)
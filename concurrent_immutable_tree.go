@@ -0,0 +1,80 @@
+package iavl
+
+import (
+	"sync"
+)
+
+// ConcurrentImmutableTree is a read-only view of the latest saved version of a MutableTree,
+// returned by MutableTree.ConcurrentVersion(). Any number of goroutines may read from it
+// concurrently, also while the MutableTree is being modified. When a new version is saved or
+// loaded, the view is atomically switched to it, waiting for in-flight reads to complete.
+//
+// Reads are always served from the tree nodes, never from the fast node index, since the
+// index is rewritten while new versions are being saved.
+//
+// Returned key/value byte slices must not be modified, since they may point to data stored
+// within IAVL.
+type ConcurrentImmutableTree struct {
+	mtx  sync.RWMutex
+	tree *ImmutableTree
+}
+
+func newConcurrentImmutableTree(tree *ImmutableTree) *ConcurrentImmutableTree {
+	ct := &ConcurrentImmutableTree{}
+	ct.publish(tree)
+	return ct
+}
+
+// publish makes tree the version seen by all subsequent reads.
+func (ct *ConcurrentImmutableTree) publish(tree *ImmutableTree) {
+	tree = tree.clone()
+	tree.skipFastStorageUpgrade = true
+
+	ct.mtx.Lock()
+	defer ct.mtx.Unlock()
+	ct.tree = tree
+}
+
+// Version returns the version of the tree.
+func (ct *ConcurrentImmutableTree) Version() int64 {
+	ct.mtx.RLock()
+	defer ct.mtx.RUnlock()
+	return ct.tree.Version()
+}
+
+// Hash returns the root hash of the tree.
+func (ct *ConcurrentImmutableTree) Hash() ([]byte, error) {
+	ct.mtx.RLock()
+	defer ct.mtx.RUnlock()
+	return ct.tree.Hash()
+}
+
+// Has returns whether or not a key exists.
+func (ct *ConcurrentImmutableTree) Has(key []byte) (bool, error) {
+	ct.mtx.RLock()
+	defer ct.mtx.RUnlock()
+	return ct.tree.Has(key)
+}
+
+// Get returns the value of the specified key if it exists, or nil otherwise.
+func (ct *ConcurrentImmutableTree) Get(key []byte) ([]byte, error) {
+	ct.mtx.RLock()
+	defer ct.mtx.RUnlock()
+	return ct.tree.Get(key)
+}
+
+// Iterate iterates over all keys of the tree. Returns true if stopped by callback, false
+// otherwise. The version seen by fn does not change until the iteration completes.
+func (ct *ConcurrentImmutableTree) Iterate(fn func(key []byte, value []byte) bool) (bool, error) {
+	ct.mtx.RLock()
+	defer ct.mtx.RUnlock()
+	return ct.tree.Iterate(fn)
+}
+
+// IterateRange makes a callback for all nodes with key between start and end non-inclusive.
+// If either are nil, then it is open on that side (nil, nil is the same as Iterate).
+func (ct *ConcurrentImmutableTree) IterateRange(start, end []byte, ascending bool, fn func(key []byte, value []byte) bool) bool {
+	ct.mtx.RLock()
+	defer ct.mtx.RUnlock()
+	return ct.tree.IterateRange(start, end, ascending, fn)
+}
@@ -0,0 +1,76 @@
+package iavl
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrentImmutableTree(t *testing.T) {
+	tree := setupMutableTree(t, false)
+	ct := tree.ConcurrentVersion()
+	require.EqualValues(t, 0, ct.Version())
+
+	_, err := tree.Set([]byte("a"), []byte{0})
+	require.NoError(t, err)
+	value, err := ct.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Nil(t, value, "unsaved changes must not be visible")
+	_, _, err = tree.Remove([]byte("a"))
+	require.NoError(t, err)
+
+	const versions = 50
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				// Every saved version contains exactly the keys 0..version-1, so a single
+				// iteration must see them in order, from a version published while it ran.
+				before := ct.Version()
+				var count int64
+				_, err := ct.Iterate(func(key, value []byte) bool {
+					require.EqualValues(t, count, binary.BigEndian.Uint64(key))
+					count++
+					return false
+				})
+				require.NoError(t, err)
+				after := ct.Version()
+				require.GreaterOrEqual(t, count, before)
+				require.LessOrEqual(t, count, after)
+			}
+		}()
+	}
+
+	for i := int64(0); i < versions; i++ {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(i))
+		_, err := tree.Set(key, key)
+		require.NoError(t, err)
+		hash, version, err := tree.SaveVersion()
+		require.NoError(t, err)
+
+		require.Equal(t, version, ct.Version())
+		ctHash, err := ct.Hash()
+		require.NoError(t, err)
+		require.Equal(t, hash, ctHash)
+		has, err := ct.Has(key)
+		require.NoError(t, err)
+		require.True(t, has)
+	}
+	close(done)
+	wg.Wait()
+
+	_, err = tree.LoadVersion(10)
+	require.NoError(t, err)
+	require.EqualValues(t, 10, ct.Version())
+}
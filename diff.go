@@ -0,0 +1,105 @@
+package iavl
+
+import (
+	"bytes"
+)
+
+// IterateVersionChanges calls fn in ascending key order for each key which differs between the
+// saved versions v1 and v2, until fn returns true. v1val is nil for keys inserted in v2, and
+// v2val is nil for keys removed in v2. Subtrees shared by both versions are skipped by comparing
+// hashes, so only the changed parts of the trees are visited. Returns true if stopped by
+// callback, false otherwise.
+func (tree *MutableTree) IterateVersionChanges(v1, v2 int64, fn func(key, v1val, v2val []byte) bool) (stopped bool, err error) {
+	t1, err := tree.GetImmutable(v1)
+	if err != nil {
+		return false, err
+	}
+	t2, err := tree.GetImmutable(v2)
+	if err != nil {
+		return false, err
+	}
+	return diffTrees(t1, t2, fn)
+}
+
+// diffTrees walks two trees side by side, calling fn for each key whose value differs. Each
+// tree is kept as a stack of subtrees which together hold its remaining keys, leftmost on top.
+// Identical subtrees at the top of both stacks hold the same keys and are skipped, otherwise
+// the taller one is split into its children until leaves can be compared.
+func diffTrees(t1, t2 *ImmutableTree, fn func(key, v1val, v2val []byte) bool) (bool, error) {
+	var stack1, stack2 []*Node
+	if t1.root != nil {
+		stack1 = append(stack1, t1.root)
+	}
+	if t2.root != nil {
+		stack2 = append(stack2, t2.root)
+	}
+
+	for len(stack1) > 0 || len(stack2) > 0 {
+		var n1, n2 *Node
+		if len(stack1) > 0 {
+			n1 = stack1[len(stack1)-1]
+		}
+		if len(stack2) > 0 {
+			n2 = stack2[len(stack2)-1]
+		}
+
+		switch {
+		case n1 != nil && n2 != nil && bytes.Equal(n1.hash, n2.hash):
+			stack1, stack2 = stack1[:len(stack1)-1], stack2[:len(stack2)-1]
+
+		case n1 != nil && !n1.isLeaf() && (n2 == nil || n1.subtreeHeight >= n2.subtreeHeight):
+			var err error
+			if stack1, err = pushChildren(t1, stack1); err != nil {
+				return false, err
+			}
+
+		case n2 != nil && !n2.isLeaf():
+			var err error
+			if stack2, err = pushChildren(t2, stack2); err != nil {
+				return false, err
+			}
+
+		default:
+			// Any remaining nodes are leaves.
+			var key, v1val, v2val []byte
+			var cmp int
+			switch {
+			case n1 == nil:
+				cmp = 1
+			case n2 == nil:
+				cmp = -1
+			default:
+				cmp = bytes.Compare(n1.key, n2.key)
+			}
+			if cmp <= 0 {
+				key, v1val = n1.key, n1.value
+				stack1 = stack1[:len(stack1)-1]
+			}
+			if cmp >= 0 {
+				key, v2val = n2.key, n2.value
+				stack2 = stack2[:len(stack2)-1]
+			}
+			if cmp == 0 && bytes.Equal(v1val, v2val) {
+				continue
+			}
+			if fn(key, v1val, v2val) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// pushChildren replaces the inner node on top of the stack with its children.
+func pushChildren(t *ImmutableTree, stack []*Node) ([]*Node, error) {
+	node := stack[len(stack)-1]
+	leftNode, err := node.getLeftNode(t)
+	if err != nil {
+		return nil, err
+	}
+	rightNode, err := node.getRightNode(t)
+	if err != nil {
+		return nil, err
+	}
+	return append(stack[:len(stack)-1], rightNode, leftNode), nil
+}
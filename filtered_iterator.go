@@ -0,0 +1,70 @@
+package iavl
+
+import (
+	dbm "github.com/cosmos/cosmos-db"
+)
+
+// FilteredIterator is a dbm.Iterator which wraps another iterator,
+// skipping all entries for which the predicate returns false.
+//
+// It still visits every entry of the underlying iterator; it cannot use
+// the tree structure to skip non-matching subtrees.
+type FilteredIterator struct {
+	iter      dbm.Iterator
+	predicate func(key, value []byte) bool
+}
+
+var _ dbm.Iterator = (*FilteredIterator)(nil)
+
+// NewFilteredIterator returns an iterator over the entries of iter matching predicate.
+func NewFilteredIterator(iter dbm.Iterator, predicate func(key, value []byte) bool) *FilteredIterator {
+	filtered := &FilteredIterator{
+		iter:      iter,
+		predicate: predicate,
+	}
+	filtered.skipUnmatched()
+	return filtered
+}
+
+// skipUnmatched advances the underlying iterator to the next matching entry.
+func (iter *FilteredIterator) skipUnmatched() {
+	for iter.iter.Valid() && !iter.predicate(iter.iter.Key(), iter.iter.Value()) {
+		iter.iter.Next()
+	}
+}
+
+// Domain implements dbm.Iterator.
+func (iter *FilteredIterator) Domain() ([]byte, []byte) {
+	return iter.iter.Domain()
+}
+
+// Valid implements dbm.Iterator.
+func (iter *FilteredIterator) Valid() bool {
+	return iter.iter.Valid()
+}
+
+// Key implements dbm.Iterator
+func (iter *FilteredIterator) Key() []byte {
+	return iter.iter.Key()
+}
+
+// Value implements dbm.Iterator
+func (iter *FilteredIterator) Value() []byte {
+	return iter.iter.Value()
+}
+
+// Next implements dbm.Iterator
+func (iter *FilteredIterator) Next() {
+	iter.iter.Next()
+	iter.skipUnmatched()
+}
+
+// Close implements dbm.Iterator
+func (iter *FilteredIterator) Close() error {
+	return iter.iter.Close()
+}
+
+// Error implements dbm.Iterator
+func (iter *FilteredIterator) Error() error {
+	return iter.iter.Error()
+}
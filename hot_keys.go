@@ -0,0 +1,144 @@
+package iavl
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrHotKeyTrackingDisabled is returned when querying hot keys without Options.HotKeyTracking.
+var ErrHotKeyTrackingDisabled = errors.New("hot key tracking is disabled")
+
+// hotKeyTracker records key accesses. The noop implementation is used when tracking is
+// disabled, so that recording costs nothing but an interface call.
+type hotKeyTracker interface {
+	record(key []byte)
+	top(n int) ([][]byte, error)
+	reset()
+}
+
+func newHotKeyTracker(capacity int) hotKeyTracker {
+	if capacity <= 0 {
+		return noopHotKeyTracker{}
+	}
+	return &spaceSavingTracker{
+		capacity: capacity,
+		entries:  make(map[string]*hotKeyEntry, capacity),
+	}
+}
+
+type noopHotKeyTracker struct{}
+
+func (noopHotKeyTracker) record([]byte) {}
+
+func (noopHotKeyTracker) top(int) ([][]byte, error) {
+	return nil, ErrHotKeyTrackingDisabled
+}
+
+func (noopHotKeyTracker) reset() {}
+
+type hotKeyEntry struct {
+	key   string
+	count uint64
+	index int // index in the heap
+}
+
+// hotKeyHeap is a min-heap of entries by access count.
+type hotKeyHeap []*hotKeyEntry
+
+func (h hotKeyHeap) Len() int           { return len(h) }
+func (h hotKeyHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+
+func (h hotKeyHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *hotKeyHeap) Push(x interface{}) {
+	entry := x.(*hotKeyEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *hotKeyHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// spaceSavingTracker tracks the most frequently accessed keys in bounded memory using the
+// Space-Saving algorithm: once capacity keys are tracked, an untracked key replaces the least
+// accessed one and inherits its count. Counts may thus be overestimated, but any key accessed
+// more often than total/capacity times is guaranteed to be tracked.
+type spaceSavingTracker struct {
+	mtx      sync.Mutex
+	capacity int
+	entries  map[string]*hotKeyEntry
+	heap     hotKeyHeap
+}
+
+func (t *spaceSavingTracker) record(key []byte) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if entry, ok := t.entries[unsafeToStr(key)]; ok {
+		entry.count++
+		heap.Fix(&t.heap, entry.index)
+		return
+	}
+
+	if len(t.heap) < t.capacity {
+		entry := &hotKeyEntry{key: string(key), count: 1}
+		t.entries[entry.key] = entry
+		heap.Push(&t.heap, entry)
+		return
+	}
+
+	entry := t.heap[0]
+	delete(t.entries, entry.key)
+	entry.key = string(key)
+	entry.count++
+	t.entries[entry.key] = entry
+	heap.Fix(&t.heap, 0)
+}
+
+func (t *spaceSavingTracker) top(n int) ([][]byte, error) {
+	if n < 0 {
+		return nil, errors.Errorf("number of hot keys must be greater or equal to 0, got %d", n)
+	}
+
+	t.mtx.Lock()
+	entries := make([]hotKeyEntry, len(t.heap))
+	for i, entry := range t.heap {
+		entries[i] = *entry
+	}
+	t.mtx.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].count != entries[j].count {
+			return entries[i].count > entries[j].count
+		}
+		return entries[i].key < entries[j].key
+	})
+	if n < len(entries) {
+		entries = entries[:n]
+	}
+
+	keys := make([][]byte, len(entries))
+	for i, entry := range entries {
+		keys[i] = []byte(entry.key)
+	}
+	return keys, nil
+}
+
+func (t *spaceSavingTracker) reset() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.entries = make(map[string]*hotKeyEntry, t.capacity)
+	t.heap = nil
+}
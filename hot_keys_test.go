@@ -0,0 +1,65 @@
+package iavl
+
+import (
+	"testing"
+
+	db "github.com/cosmos/cosmos-db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMutableTree_HotKeys(t *testing.T) {
+	opts := DefaultOptions()
+	opts.HotKeyTracking = 3
+	tree, err := NewMutableTreeWithOpts(db.NewMemDB(), 0, &opts, false)
+	require.NoError(t, err)
+
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		_, err = tree.Set([]byte(k), []byte(k))
+		require.NoError(t, err)
+	}
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+
+	reads := map[string]int{"a": 5, "b": 1, "c": 10, "e": 3}
+	for k, n := range reads {
+		for i := 0; i < n; i++ {
+			_, err = tree.Get([]byte(k))
+			require.NoError(t, err)
+		}
+	}
+
+	hot, err := tree.HotKeys(2)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("c"), []byte("a")}, hot)
+
+	// Reads through an immutable tree are tracked as well.
+	itree, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		_, err = itree.Get([]byte("d"))
+		require.NoError(t, err)
+	}
+	hot, err = tree.HotKeys(10)
+	require.NoError(t, err)
+	require.Len(t, hot, 3)
+	require.Equal(t, []byte("d"), hot[0])
+
+	tree.ResetHotKeys()
+	hot, err = tree.HotKeys(10)
+	require.NoError(t, err)
+	require.Empty(t, hot)
+
+	_, err = tree.HotKeys(-1)
+	require.Error(t, err)
+}
+
+func TestMutableTree_HotKeys_Disabled(t *testing.T) {
+	tree := setupMutableTree(t, false)
+	_, err := tree.Set([]byte("a"), []byte("a"))
+	require.NoError(t, err)
+	_, err = tree.Get([]byte("a"))
+	require.NoError(t, err)
+
+	_, err = tree.HotKeys(1)
+	require.ErrorIs(t, err, ErrHotKeyTrackingDisabled)
+}
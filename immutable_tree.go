@@ -1,12 +1,28 @@
 package iavl
 
 import (
+	"bytes"
 	"fmt"
+	"math/big"
+	"math/bits"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 
 	dbm "github.com/cosmos/cosmos-db"
+	"github.com/pkg/errors"
 )
 
+// ErrEmptyTree is returned when querying the boundary keys of a tree without keys.
+var ErrEmptyTree = errors.New("tree is empty")
+
+// ErrRankOutOfBounds is returned when a requested rank range is not within the tree.
+var ErrRankOutOfBounds = errors.New("rank out of bounds")
+
+// ErrNodeNotFound is returned when a node with a requested hash is not part of the tree.
+var ErrNodeNotFound = errors.New("node not found")
+
 // ImmutableTree contains the immutable tree at a given version. It is typically created by calling
 // MutableTree.GetImmutable(), in which case the returned tree is safe for concurrent access as
 // long as the version is not deleted via DeleteVersion() or the tree's pruning settings.
@@ -147,9 +163,17 @@ func (t *ImmutableTree) Has(key []byte) (bool, error) {
 	return t.root.has(t, key)
 }
 
+// hashObserver returns the observer to call for inner node hashes, if any.
+func (t *ImmutableTree) hashObserver() hashObserver {
+	if t.ndb == nil {
+		return nil
+	}
+	return t.ndb.hashObserver
+}
+
 // Hash returns the root hash.
 func (t *ImmutableTree) Hash() ([]byte, error) {
-	hash, _, err := t.root.hashWithCount()
+	hash, _, err := t.root.hashWithCount(t.hashObserver())
 	return hash, err
 }
 
@@ -165,6 +189,12 @@ func (t *ImmutableTree) Export() *Exporter {
 //
 // The index is the index in the list of leaf nodes sorted lexicographically by key. The leftmost leaf has index 0.
 // It's neighbor has index 1 and so on.
+//
+// Since the index is returned even if the key does not exist, it should not be used to check for
+// existence.
+//
+// Deprecated: use Get for the value, and AbsoluteIndex for the index of an existing key, or
+// BinarySearch for the index a missing key would have.
 func (t *ImmutableTree) GetWithIndex(key []byte) (int64, []byte, error) {
 	if t.root == nil {
 		return 0, nil, nil
@@ -172,6 +202,19 @@ func (t *ImmutableTree) GetWithIndex(key []byte) (int64, []byte, error) {
 	return t.root.get(t, key)
 }
 
+// AbsoluteIndex returns the 0-based index of the key in the list of keys sorted
+// lexicographically, or ErrKeyNotFound if the key does not exist.
+func (t *ImmutableTree) AbsoluteIndex(key []byte) (rank int64, err error) {
+	match, rank, _, _, err := t.binarySearch(key)
+	if err != nil {
+		return 0, err
+	}
+	if match == nil {
+		return 0, errors.Wrapf(ErrKeyNotFound, "%X", key)
+	}
+	return rank, nil
+}
+
 // Get returns the value of the specified key if it exists, or nil.
 // The returned value must not be modified, since it may point to data stored within IAVL.
 // Get potentially employs a more performant strategy than GetWithIndex for retrieving the value.
@@ -180,7 +223,25 @@ func (t *ImmutableTree) Get(key []byte) ([]byte, error) {
 	if t.root == nil {
 		return nil, nil
 	}
+	t.ndb.hotKeys.record(key)
+	t.ndb.keyMetrics.recordGet(key)
+	return t.get(key)
+}
+
+// GetOrDefault is like Get, but returns defaultValue if the key does not exist.
+func (t *ImmutableTree) GetOrDefault(key, defaultValue []byte) ([]byte, error) {
+	value, err := t.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return defaultValue, nil
+	}
+	return value, nil
+}
 
+// get is like Get, but does not record the access.
+func (t *ImmutableTree) get(key []byte) ([]byte, error) {
 	if !t.skipFastStorageUpgrade {
 		// attempt to get a FastNode directly from db/cache.
 		// if call fails, fall back to the original IAVL logic in place.
@@ -214,6 +275,39 @@ func (t *ImmutableTree) Get(key []byte) ([]byte, error) {
 	return result, err
 }
 
+// GetAndTouch is like Get, but also marks the nodes on the path to the key as most recently used
+// in the node cache, so that they are the last to be evicted.
+func (t *ImmutableTree) GetAndTouch(key []byte) ([]byte, error) {
+	value, err := t.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.touchPath(key); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// touchPath marks the cached nodes on the path to key as most recently used.
+func (t *ImmutableTree) touchPath(key []byte) (err error) {
+	node := t.root
+	for node != nil {
+		t.ndb.touchNode(node.hash)
+		if node.isLeaf() {
+			break
+		}
+		if bytes.Compare(key, node.key) < 0 {
+			node, err = node.getLeftNode(t)
+		} else {
+			node, err = node.getRightNode(t)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // GetByIndex gets the key and value at the specified index.
 func (t *ImmutableTree) GetByIndex(index int64) (key []byte, value []byte, err error) {
 	if t.root == nil {
@@ -223,6 +317,279 @@ func (t *ImmutableTree) GetByIndex(index int64) (key []byte, value []byte, err e
 	return t.root.getByIndex(t, index)
 }
 
+// IterateFromIndex iterates in ascending order over the keys of the tree, starting at the key
+// with the given 0-based index. Returns true if stopped by callback, false otherwise.
+func (t *ImmutableTree) IterateFromIndex(startIndex int64, fn func(key []byte, value []byte) bool) (stopped bool, err error) {
+	if startIndex < 0 {
+		return false, errors.Errorf("start index must be greater or equal to 0, got %d", startIndex)
+	}
+	if t.root == nil || startIndex >= t.root.size {
+		return false, nil
+	}
+
+	startKey, _, err := t.root.getByIndex(t, startIndex)
+	if err != nil {
+		return false, err
+	}
+	return t.traverseLeaves(startKey, nil, true, false, func(node *Node) bool {
+		return fn(node.key, node.value)
+	})
+}
+
+// KeyRangeByRank returns the keys and values with 0-based rank between startRank (inclusive)
+// and endRank (exclusive). Returns ErrRankOutOfBounds unless 0 <= startRank <= endRank <= Size().
+func (t *ImmutableTree) KeyRangeByRank(startRank, endRank int64) (keys [][]byte, values [][]byte, err error) {
+	if startRank < 0 || startRank > endRank || endRank > t.Size() {
+		return nil, nil, errors.Wrapf(ErrRankOutOfBounds, "[%d, %d) with size %d", startRank, endRank, t.Size())
+	}
+	if startRank == endRank {
+		return [][]byte{}, [][]byte{}, nil
+	}
+
+	keys = make([][]byte, 0, endRank-startRank)
+	values = make([][]byte, 0, endRank-startRank)
+	_, err = t.IterateFromIndex(startRank, func(key []byte, value []byte) bool {
+		keys = append(keys, key)
+		values = append(values, value)
+		return int64(len(keys)) == endRank-startRank
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return keys, values, nil
+}
+
+// ExclusiveRange returns the keys and values in the open interval (start, end), in ascending
+// order. A nil start or end is unbounded.
+func (t *ImmutableTree) ExclusiveRange(start, end []byte) (keys, values [][]byte, err error) {
+	return t.collectRange(start, end, false)
+}
+
+// InclusiveRange returns the keys and values in the closed interval [start, end], in ascending
+// order. A nil start or end is unbounded.
+func (t *ImmutableTree) InclusiveRange(start, end []byte) (keys, values [][]byte, err error) {
+	return t.collectRange(start, end, true)
+}
+
+// collectRange returns the keys and values between start and end, which are both included if
+// inclusive is true, or both excluded otherwise.
+func (t *ImmutableTree) collectRange(start, end []byte, inclusive bool) (keys, values [][]byte, err error) {
+	keys, values = [][]byte{}, [][]byte{}
+	if t.root == nil {
+		return keys, values, nil
+	}
+	_, err = t.traverseLeaves(start, end, true, inclusive, func(node *Node) bool {
+		if !inclusive && start != nil && bytes.Equal(node.key, start) {
+			return false
+		}
+		keys = append(keys, node.key)
+		values = append(values, node.value)
+		return false
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return keys, values, nil
+}
+
+// PartitionByCount returns the n-1 keys which split the tree into n partitions of nearly equal
+// key count, for sharding. Partition i holds the keys from boundary i-1 (inclusive) up to
+// boundary i (exclusive), with the first and last partitions being open-ended. The sizes of the
+// partitions differ by at most one key. n must be between 1 and Size(), unless the tree is empty
+// and n is 1.
+func (t *ImmutableTree) PartitionByCount(n int) (partitions [][]byte, err error) {
+	size := t.Size()
+	if n < 1 || (int64(n) > size && n > 1) {
+		return nil, errors.Errorf("number of partitions must be between 1 and %d, got %d", size, n)
+	}
+
+	partitions = make([][]byte, 0, n-1)
+	for i := int64(1); i < int64(n); i++ {
+		key, _, err := t.root.getByIndex(t, i*size/int64(n))
+		if err != nil {
+			return nil, err
+		}
+		partitions = append(partitions, key)
+	}
+	return partitions, nil
+}
+
+// BinarySearch looks up key in the tree. It returns whether the key exists, its rank (the
+// 0-based index of the key, or the index it would have if it were inserted), and the nearest
+// keys strictly before and after it, which are nil when there is no such key.
+func (t *ImmutableTree) BinarySearch(key []byte) (exactMatch bool, rank int64, leftKey, rightKey []byte, err error) {
+	match, rank, left, right, err := t.binarySearch(key)
+	if err != nil {
+		return false, 0, nil, nil, err
+	}
+	if left != nil {
+		leftKey = left.key
+	}
+	if right != nil {
+		rightKey = right.key
+	}
+	return match != nil, rank, leftKey, rightKey, nil
+}
+
+// GetNearest returns the key nearest to the given key, and its value. This is the key itself if
+// it exists, otherwise the closer of its neighbours, with ties going to the predecessor. The
+// distance between keys is the difference of their big-endian integer values, after padding them
+// with trailing zeros to the same length. Returns ErrEmptyTree if the tree has no keys.
+func (t *ImmutableTree) GetNearest(key []byte) (nearestKey, value []byte, err error) {
+	if t.root == nil {
+		return nil, nil, ErrEmptyTree
+	}
+	match, _, left, right, err := t.binarySearch(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if match != nil {
+		return match.key, match.value, nil
+	}
+
+	nearest := left
+	if left == nil {
+		nearest = right
+	} else if right != nil {
+		width := len(key)
+		if len(left.key) > width {
+			width = len(left.key)
+		}
+		if len(right.key) > width {
+			width = len(right.key)
+		}
+		if keyDistance(right.key, key, width).Cmp(keyDistance(key, left.key, width)) < 0 {
+			nearest = right
+		}
+	}
+	return nearest.key, nearest.value, nil
+}
+
+// keyDistance returns the distance from a to b, where a >= b, as integers of width bytes.
+func keyDistance(a, b []byte, width int) *big.Int {
+	padded := make([]byte, width)
+	copy(padded, a)
+	distance := new(big.Int).SetBytes(padded)
+	padded = make([]byte, width)
+	copy(padded, b)
+	return distance.Sub(distance, new(big.Int).SetBytes(padded))
+}
+
+// binarySearch is like BinarySearch, but returns the leaf node of the key if it exists, and the
+// neighbouring leaf nodes.
+func (t *ImmutableTree) binarySearch(key []byte) (match *Node, rank int64, left, right *Node, err error) {
+	if t.root == nil {
+		return nil, 0, nil, nil, nil
+	}
+
+	// lowerSubtree is the left subtree at the last turn right, holding the keys just before
+	// the path, and upperKeyNode is the node at the last turn left, whose key is the first key
+	// after the path.
+	var lowerSubtree, upperKeyNode *Node
+	node := t.root
+	for !node.isLeaf() {
+		leftNode, err := node.getLeftNode(t)
+		if err != nil {
+			return nil, 0, nil, nil, err
+		}
+		if bytes.Compare(key, node.key) < 0 {
+			upperKeyNode = node
+			node = leftNode
+		} else {
+			lowerSubtree = leftNode
+			rank += leftNode.size
+			node, err = node.getRightNode(t)
+			if err != nil {
+				return nil, 0, nil, nil, err
+			}
+		}
+	}
+
+	// The leaf reached is the key itself, or one of its neighbours.
+	switch bytes.Compare(node.key, key) {
+	case -1:
+		left = node
+		rank++
+	case 1:
+		right = node
+	default:
+		match = node
+	}
+
+	if left == nil && lowerSubtree != nil {
+		left = lowerSubtree
+		for !left.isLeaf() {
+			if left, err = left.getRightNode(t); err != nil {
+				return nil, 0, nil, nil, err
+			}
+		}
+	}
+	if right == nil && upperKeyNode != nil {
+		right, err = upperKeyNode.getRightNode(t)
+		if err != nil {
+			return nil, 0, nil, nil, err
+		}
+		for !right.isLeaf() {
+			if right, err = right.getLeftNode(t); err != nil {
+				return nil, 0, nil, nil, err
+			}
+		}
+	}
+	return match, rank, left, right, nil
+}
+
+// FirstKey returns the smallest key in the tree, or ErrEmptyTree if the tree has no keys.
+func (t *ImmutableTree) FirstKey() (key []byte, err error) {
+	if t.root == nil {
+		return nil, ErrEmptyTree
+	}
+	node := t.root
+	for !node.isLeaf() {
+		node, err = node.getLeftNode(t)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return node.key, nil
+}
+
+// LastKey returns the largest key in the tree, or ErrEmptyTree if the tree has no keys.
+func (t *ImmutableTree) LastKey() (key []byte, err error) {
+	if t.root == nil {
+		return nil, ErrEmptyTree
+	}
+	node := t.root
+	for !node.isLeaf() {
+		node, err = node.getRightNode(t)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return node.key, nil
+}
+
+// KeyRange returns the smallest and largest keys in the tree, or ErrEmptyTree if the tree has no
+// keys. Both sides of the tree are walked down together, in a single pass.
+func (t *ImmutableTree) KeyRange() (minKey, maxKey []byte, err error) {
+	if t.root == nil {
+		return nil, nil, ErrEmptyTree
+	}
+	left, right := t.root, t.root
+	for !left.isLeaf() || !right.isLeaf() {
+		if !left.isLeaf() {
+			if left, err = left.getLeftNode(t); err != nil {
+				return nil, nil, err
+			}
+		}
+		if !right.isLeaf() {
+			if right, err = right.getRightNode(t); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return left.key, right.key, nil
+}
+
 // Iterate iterates over all keys of the tree. The keys and values must not be modified,
 // since they may point to data stored within IAVL. Returns true if stopped by callback, false otherwise
 func (t *ImmutableTree) Iterate(fn func(key []byte, value []byte) bool) (bool, error) {
@@ -289,6 +656,405 @@ func (t *ImmutableTree) IterateRangeInclusive(start, end []byte, ascending bool,
 	})
 }
 
+// KeyValueSizes returns histograms of the key and value sizes in the tree, mapping each bucket
+// to the number of keys in it. Keys are bucketed by their length, and values by their length
+// rounded up to a power of two, with empty values in bucket 0.
+func (t *ImmutableTree) KeyValueSizes() (keySizeHistogram, valueSizeHistogram map[int]int, err error) {
+	keySizeHistogram = map[int]int{}
+	valueSizeHistogram = map[int]int{}
+	if t.root == nil {
+		return keySizeHistogram, valueSizeHistogram, nil
+	}
+	_, err = t.traverseLeaves(nil, nil, true, false, func(node *Node) bool {
+		keySizeHistogram[len(node.key)]++
+		valueSizeHistogram[sizeBucket(len(node.value))]++
+		return false
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return keySizeHistogram, valueSizeHistogram, nil
+}
+
+// sizeBucket rounds size up to a power of two, or returns 0 for 0.
+func sizeBucket(size int) int {
+	if size == 0 {
+		return 0
+	}
+	return 1 << bits.Len(uint(size-1))
+}
+
+// SubTreeIterator returns an iterator over the leaves of the subtree whose root node has the
+// given hash, in ascending order. Returns ErrNodeNotFound if no node of the tree has the hash.
+func (t *ImmutableTree) SubTreeIterator(subtreeRootHash []byte) (dbm.Iterator, error) {
+	node, err := t.findNode(subtreeRootHash)
+	if err != nil {
+		return nil, err
+	}
+	subtree := &ImmutableTree{
+		root:                   node,
+		ndb:                    t.ndb,
+		version:                t.version,
+		skipFastStorageUpgrade: true,
+	}
+	return NewIterator(nil, nil, true, subtree), nil
+}
+
+// findNode returns the node of the tree with the given hash. Unsaved nodes are searched in
+// memory, while persisted nodes are loaded from the database, and located in the tree by
+// following the path to their leftmost leaf.
+func (t *ImmutableTree) findNode(hash []byte) (*Node, error) {
+	if t.root == nil || len(hash) == 0 {
+		return nil, errors.Wrapf(ErrNodeNotFound, "%X", hash)
+	}
+	if _, err := t.Hash(); err != nil {
+		return nil, err
+	}
+
+	// Persisted nodes only have persisted descendants, so only unsaved nodes need searching.
+	unsaved := []*Node{t.root}
+	for len(unsaved) > 0 {
+		node := unsaved[len(unsaved)-1]
+		unsaved = unsaved[:len(unsaved)-1]
+		if bytes.Equal(node.hash, hash) {
+			return node, nil
+		}
+		if node.persisted || node.isLeaf() {
+			continue
+		}
+		for _, child := range []*Node{node.leftNode, node.rightNode} {
+			if child != nil {
+				unsaved = append(unsaved, child)
+			}
+		}
+	}
+	if t.ndb == nil {
+		return nil, errors.Wrapf(ErrNodeNotFound, "%X", hash)
+	}
+
+	exists, err := t.ndb.db.Has(t.ndb.nodeKey(hash))
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.Wrapf(ErrNodeNotFound, "%X", hash)
+	}
+	candidate, err := t.ndb.GetNode(hash)
+	if err != nil {
+		return nil, err
+	}
+	leftmost := candidate
+	for !leftmost.isLeaf() {
+		if leftmost, err = leftmost.getLeftNode(t); err != nil {
+			return nil, err
+		}
+	}
+
+	// The node is an ancestor of its leftmost leaf, so it must be on the path to it.
+	node := t.root
+	for {
+		if bytes.Equal(node.hash, hash) {
+			return node, nil
+		}
+		if node.isLeaf() {
+			return nil, errors.Wrapf(ErrNodeNotFound, "%X", hash)
+		}
+		if bytes.Compare(leftmost.key, node.key) < 0 {
+			node, err = node.getLeftNode(t)
+		} else {
+			node, err = node.getRightNode(t)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// IterateParallel calls fn for every key of the tree, using the given number of goroutines.
+// The key space is split into equal partitions with PartitionByCount(), one per goroutine, and
+// each partition is iterated in ascending order. fn is called concurrently, and must be safe for
+// concurrent use. Returns the first error encountered, by partition order.
+func (t *ImmutableTree) IterateParallel(workers int, fn func(key []byte, value []byte)) error {
+	if workers < 1 {
+		return errors.Errorf("number of workers must be at least 1, got %d", workers)
+	}
+	if t.root == nil {
+		return nil
+	}
+	if int64(workers) > t.root.size {
+		workers = int(t.root.size)
+	}
+	boundaries, err := t.PartitionByCount(workers)
+	if err != nil {
+		return err
+	}
+
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		var start, end []byte
+		if i > 0 {
+			start = boundaries[i-1]
+		}
+		if i < workers-1 {
+			end = boundaries[i]
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = t.traverseLeaves(start, end, true, false, func(node *Node) bool {
+				fn(node.key, node.value)
+				return false
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IterateInOrder calls fn in ascending order for each key between start (inclusive) and end
+// (exclusive), along with the depth of its leaf: the distance from the root, which has depth 0.
+// If either start or end is nil, the range is open on that side. Returns true if stopped by
+// callback, false otherwise.
+func (t *ImmutableTree) IterateInOrder(start, end []byte, fn func(key, value []byte, depth int) bool) (stopped bool, err error) {
+	if t.root == nil {
+		return false, nil
+	}
+	return t.iterateInOrder(t.root, start, end, 0, fn)
+}
+
+func (t *ImmutableTree) iterateInOrder(node *Node, start, end []byte, depth int, fn func(key, value []byte, depth int) bool) (bool, error) {
+	if node.isLeaf() {
+		if (start != nil && bytes.Compare(node.key, start) < 0) || (end != nil && bytes.Compare(node.key, end) >= 0) {
+			return false, nil
+		}
+		return fn(node.key, node.value, depth), nil
+	}
+
+	// Keys of the left subtree are before node.key, and keys of the right subtree are not.
+	if start == nil || bytes.Compare(start, node.key) < 0 {
+		leftNode, err := node.getLeftNode(t)
+		if err != nil {
+			return false, err
+		}
+		if stopped, err := t.iterateInOrder(leftNode, start, end, depth+1, fn); stopped || err != nil {
+			return stopped, err
+		}
+	}
+	if end == nil || bytes.Compare(node.key, end) < 0 {
+		rightNode, err := node.getRightNode(t)
+		if err != nil {
+			return false, err
+		}
+		return t.iterateInOrder(rightNode, start, end, depth+1, fn)
+	}
+	return false, nil
+}
+
+// HashesForRange returns the leaf hash of each key in [start, end) in ascending key order.
+// These are the hashes a RangeProof would commit to, without building the inner node paths.
+func (t *ImmutableTree) HashesForRange(start, end []byte) ([][]byte, error) {
+	return t.collectLeafHashes(start, end, nil)
+}
+
+// IterateAndCollectHashes calls fn for each key in ascending order, and returns the leaf hashes of
+// the keys for which fn returns true, in the same order. Unlike Iterate, returning false from fn
+// does not stop the iteration.
+func (t *ImmutableTree) IterateAndCollectHashes(fn func(key, value []byte) bool) ([][]byte, error) {
+	return t.collectLeafHashes(nil, nil, fn)
+}
+
+// collectLeafHashes returns the leaf hashes of the keys in [start, end) in ascending order,
+// skipping keys for which filter returns false, if set.
+func (t *ImmutableTree) collectLeafHashes(start, end []byte, filter func(key, value []byte) bool) ([][]byte, error) {
+	hashes := [][]byte{}
+	if t.root == nil {
+		return hashes, nil
+	}
+	var hashErr error
+	_, err := t.traverseLeaves(start, end, true, false, func(node *Node) bool {
+		if filter != nil && !filter(node.key, node.value) {
+			return false
+		}
+		var hash []byte
+		hash, hashErr = node._hash()
+		if hashErr != nil {
+			return true
+		}
+		hashes = append(hashes, hash)
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if hashErr != nil {
+		return nil, hashErr
+	}
+	return hashes, nil
+}
+
+// IterateWithLeafHash calls fn in ascending order for each key in [start, end) with its value and
+// leaf hash, until fn returns true. A nil start or end is unbounded. Hashes are only computed for
+// leaves which have not been hashed yet. Returns true if stopped by callback, false otherwise.
+func (t *ImmutableTree) IterateWithLeafHash(start, end []byte, fn func(key, value, hash []byte) bool) (stopped bool, err error) {
+	if t.root == nil {
+		return false, nil
+	}
+	var hashErr error
+	stopped, err = t.traverseLeaves(start, end, true, false, func(node *Node) bool {
+		hash := node.hash
+		if hash == nil {
+			hash, _, hashErr = node.hashWithCount(t.hashObserver())
+			if hashErr != nil {
+				return true
+			}
+		}
+		return fn(node.key, node.value, hash)
+	})
+	if err != nil {
+		return false, err
+	}
+	if hashErr != nil {
+		return false, hashErr
+	}
+	return stopped, nil
+}
+
+// traverseLeaves calls fn for each leaf with key between start and end, until fn returns true.
+// end is only included if inclusive is true. Unlike IterateRange, errors encountered while
+// loading nodes are returned to the caller.
+func (t *ImmutableTree) traverseLeaves(start, end []byte, ascending bool, inclusive bool, fn func(*Node) bool) (stopped bool, err error) {
+	trav := t.root.newTraversal(t, start, end, ascending, inclusive, false)
+	for {
+		node, err := trav.next()
+		if err != nil {
+			return false, err
+		}
+		if node == nil {
+			return false, nil
+		}
+		if node.isLeaf() && fn(node) {
+			return true, nil
+		}
+	}
+}
+
+// IterateOrdered calls fn in ascending key order for each of the given keys which exists in the
+// tree, until fn returns true. Keys which do not exist are skipped. The keys are looked up in a
+// single walk of the tree, which is faster than getting them one by one. Returns true if
+// stopped by callback, false otherwise.
+func (t *ImmutableTree) IterateOrdered(keys [][]byte, fn func(key []byte, value []byte) bool) (stopped bool, err error) {
+	if t.root == nil || len(keys) == 0 {
+		return false, nil
+	}
+	sorted := make([][]byte, len(keys))
+	copy(sorted, keys)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+	return t.iterateOrdered(t.root, sorted, fn)
+}
+
+// MultiHas returns whether or not each of the given keys exists, in the order of the keys. The
+// keys are looked up in a single walk of the tree, as in IterateOrdered().
+func (t *ImmutableTree) MultiHas(keys [][]byte) ([]bool, error) {
+	found := make(map[string]bool, len(keys))
+	_, err := t.IterateOrdered(keys, func(key []byte, _ []byte) bool {
+		found[string(key)] = true
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	has := make([]bool, len(keys))
+	for i, key := range keys {
+		has[i] = found[string(key)]
+	}
+	return has, nil
+}
+
+// PrewarmPaths loads the nodes on the paths from the root to each of the given keys into the
+// node cache, so that later reads of the keys don't hit the database. The paths are loaded
+// concurrently, and PrewarmPaths returns once they are all loaded, or an error occurs. Only as
+// many nodes as fit in the cache stay loaded.
+func (t *ImmutableTree) PrewarmPaths(keys [][]byte) error {
+	if t.root == nil || len(keys) == 0 {
+		return nil
+	}
+	sorted := make([][]byte, len(keys))
+	copy(sorted, keys)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+
+	// Each worker loads the paths of a contiguous range of keys, sharing their common prefixes.
+	workers := runtime.NumCPU()
+	if workers > len(sorted) {
+		workers = len(sorted)
+	}
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			chunk := sorted[i*len(sorted)/workers : (i+1)*len(sorted)/workers]
+			_, errs[i] = t.iterateOrdered(t.root, chunk, func([]byte, []byte) bool { return false })
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// iterateOrdered looks up the sorted keys in the subtree of node, splitting them between the
+// left and right subtrees at each inner node.
+func (t *ImmutableTree) iterateOrdered(node *Node, keys [][]byte, fn func(key []byte, value []byte) bool) (bool, error) {
+	if len(keys) == 0 {
+		return false, nil
+	}
+	if node.isLeaf() {
+		for _, key := range keys {
+			if bytes.Equal(key, node.key) {
+				return fn(node.key, node.value), nil
+			}
+		}
+		return false, nil
+	}
+
+	split := sort.Search(len(keys), func(i int) bool {
+		return bytes.Compare(keys[i], node.key) >= 0
+	})
+	if split > 0 {
+		leftNode, err := node.getLeftNode(t)
+		if err != nil {
+			return false, err
+		}
+		if stopped, err := t.iterateOrdered(leftNode, keys[:split], fn); stopped || err != nil {
+			return stopped, err
+		}
+	}
+	if split == len(keys) {
+		return false, nil
+	}
+	rightNode, err := node.getRightNode(t)
+	if err != nil {
+		return false, err
+	}
+	return t.iterateOrdered(rightNode, keys[split:], fn)
+}
+
 // IsFastCacheEnabled returns true if fast cache is enabled, false otherwise.
 // For fast cache to be enabled, the following 2 conditions must be met:
 // 1. The tree is of the latest version.
@@ -309,6 +1075,54 @@ func (t *ImmutableTree) isLatestTreeVersion() (bool, error) {
 	return t.version == latestVersion, nil
 }
 
+// findCorruptedLeaves walks the whole tree and returns the leaf nodes whose stored hash does
+// not match their contents. Corrupted inner nodes can't be repaired by key, so an error is
+// returned if one is found.
+func (t *ImmutableTree) findCorruptedLeaves() ([]*Node, error) {
+	if t.root == nil {
+		return nil, nil
+	}
+	// Ensure that all hashes are calculated.
+	if _, _, err := t.root.hashWithCount(t.hashObserver()); err != nil {
+		return nil, err
+	}
+	corrupted := []*Node{}
+	if err := t.collectCorruptedLeaves(t.root, &corrupted); err != nil {
+		return nil, err
+	}
+	return corrupted, nil
+}
+
+func (t *ImmutableTree) collectCorruptedLeaves(node *Node, corrupted *[]*Node) error {
+	ok, err := node.verifyHash()
+	if err != nil {
+		return err
+	}
+	if node.isLeaf() {
+		if !ok {
+			*corrupted = append(*corrupted, node)
+		}
+		return nil
+	}
+	if !ok {
+		return errors.Errorf("inner node %X is corrupted", node.hash)
+	}
+
+	leftNode, err := node.getLeftNode(t)
+	if err != nil {
+		return err
+	}
+	if err := t.collectCorruptedLeaves(leftNode, corrupted); err != nil {
+		return err
+	}
+
+	rightNode, err := node.getRightNode(t)
+	if err != nil {
+		return err
+	}
+	return t.collectCorruptedLeaves(rightNode, corrupted)
+}
+
 // Clone creates a clone of the tree.
 // Used internally by MutableTree.
 func (t *ImmutableTree) clone() *ImmutableTree {
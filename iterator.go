@@ -228,8 +228,8 @@ func (iter *Iterator) Next() {
 	}
 
 	node, err := iter.t.next()
-	// TODO: double-check if this error is correctly handled.
 	if node == nil || err != nil {
+		iter.err = err
 		iter.t = nil
 		iter.valid = false
 		return
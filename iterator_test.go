@@ -330,3 +330,36 @@ func setupUnsavedFastIterator(t *testing.T, config *iteratorTestConfig) (dbm.Ite
 	itr := NewUnsavedFastIterator(config.startIterate, config.endIterate, config.ascending, tree.ndb, tree.unsavedFastNodeAdditions, tree.unsavedFastNodeRemovals)
 	return itr, mirror
 }
+
+func TestFilteredIterator(t *testing.T) {
+	tree, err := NewMutableTree(dbm.NewMemDB(), 0, false)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err = tree.Set([]byte{byte(i)}, []byte{byte(i % 3)})
+		require.NoError(t, err)
+	}
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	_, err = tree.Set([]byte{0x0a}, []byte{0x00})
+	require.NoError(t, err)
+
+	itr, err := tree.FilteredIterator([]byte{0x01}, nil, true, func(key, value []byte) bool {
+		return value[0] == 0
+	})
+	require.NoError(t, err)
+	defer itr.Close()
+
+	keys := [][]byte{}
+	for ; itr.Valid(); itr.Next() {
+		keys = append(keys, itr.Key())
+	}
+	require.NoError(t, itr.Error())
+	require.Equal(t, [][]byte{{0x03}, {0x06}, {0x09}, {0x0a}}, keys)
+
+	itr, err = tree.FilteredIterator(nil, nil, false, func(key, value []byte) bool {
+		return false
+	})
+	require.NoError(t, err)
+	require.False(t, itr.Valid())
+	require.NoError(t, itr.Close())
+}
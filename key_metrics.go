@@ -0,0 +1,111 @@
+package iavl
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DefaultKeyMetricsSize is the maximum number of keys with metrics tracked when
+// Options.KeyMetricsSize is 0.
+const DefaultKeyMetricsSize = 10000
+
+// KeyMetrics holds access statistics for a single key, see MutableTree.PerKeyMetrics(). Cache
+// hits and misses count lookups of the key in the fast node cache when reading it, which are only
+// made with fast storage enabled.
+type KeyMetrics struct {
+	GetCount    int64
+	SetCount    int64
+	CacheHits   int64
+	CacheMisses int64
+}
+
+type keyMetricsEntry struct {
+	key     string
+	metrics KeyMetrics
+}
+
+// keyMetricsTracker records the metrics of the most recently accessed keys, evicting the least
+// recently accessed key once capacity keys are tracked. A nil tracker records nothing.
+type keyMetricsTracker struct {
+	mtx      sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	lru      *list.List // Most recently accessed first.
+}
+
+func newKeyMetricsTracker(capacity int) *keyMetricsTracker {
+	if capacity == 0 {
+		capacity = DefaultKeyMetricsSize
+	}
+	if capacity < 0 {
+		return nil
+	}
+	return &keyMetricsTracker{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// update applies fn to the metrics of key, and marks it as the most recently accessed.
+func (t *keyMetricsTracker) update(key []byte, fn func(*KeyMetrics)) {
+	if t == nil {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if elem, ok := t.entries[unsafeToStr(key)]; ok {
+		t.lru.MoveToFront(elem)
+		fn(&elem.Value.(*keyMetricsEntry).metrics)
+		return
+	}
+
+	entry := &keyMetricsEntry{key: string(key)}
+	fn(&entry.metrics)
+	t.entries[entry.key] = t.lru.PushFront(entry)
+	if t.lru.Len() > t.capacity {
+		oldest := t.lru.Remove(t.lru.Back()).(*keyMetricsEntry)
+		delete(t.entries, oldest.key)
+	}
+}
+
+func (t *keyMetricsTracker) recordGet(key []byte) {
+	t.update(key, func(m *KeyMetrics) { m.GetCount++ })
+}
+
+func (t *keyMetricsTracker) recordSet(key []byte) {
+	t.update(key, func(m *KeyMetrics) { m.SetCount++ })
+}
+
+func (t *keyMetricsTracker) recordCacheLookup(key []byte, hit bool) {
+	t.update(key, func(m *KeyMetrics) {
+		if hit {
+			m.CacheHits++
+		} else {
+			m.CacheMisses++
+		}
+	})
+}
+
+func (t *keyMetricsTracker) get(key []byte) KeyMetrics {
+	if t == nil {
+		return KeyMetrics{}
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if elem, ok := t.entries[unsafeToStr(key)]; ok {
+		return elem.Value.(*keyMetricsEntry).metrics
+	}
+	return KeyMetrics{}
+}
+
+func (t *keyMetricsTracker) reset() {
+	if t == nil {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.entries = make(map[string]*list.Element)
+	t.lru.Init()
+}
@@ -0,0 +1,103 @@
+package iavl
+
+import (
+	"testing"
+
+	db "github.com/cosmos/cosmos-db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMutableTree_PerKeyMetrics(t *testing.T) {
+	memDB := db.NewMemDB()
+	tree, err := NewMutableTree(memDB, 0, false)
+	require.NoError(t, err)
+
+	for _, value := range []string{"1", "2"} {
+		_, err = tree.Set([]byte("a"), []byte(value))
+		require.NoError(t, err)
+	}
+	for i := 0; i < 3; i++ {
+		_, err = tree.Get([]byte("a"))
+		require.NoError(t, err)
+	}
+	require.Equal(t, KeyMetrics{GetCount: 3, SetCount: 2}, tree.PerKeyMetrics([]byte("a")))
+	require.Equal(t, KeyMetrics{}, tree.PerKeyMetrics([]byte("b")))
+	_, err = tree.Set([]byte("b"), []byte("b"))
+	require.NoError(t, err)
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+
+	// The first read loads the fast node into the cache, the second one hits it.
+	reloaded, err := NewMutableTree(memDB, 0, false)
+	require.NoError(t, err)
+	_, err = reloaded.Load()
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		value, err := reloaded.Get([]byte("a"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("2"), value)
+	}
+	require.Equal(t, KeyMetrics{GetCount: 2, CacheHits: 1, CacheMisses: 1}, reloaded.PerKeyMetrics([]byte("a")))
+
+	reloaded.ResetKeyMetrics()
+	require.Equal(t, KeyMetrics{}, reloaded.PerKeyMetrics([]byte("a")))
+}
+
+func TestMutableTree_PerKeyMetrics_Eviction(t *testing.T) {
+	opts := DefaultOptions()
+	opts.KeyMetricsSize = 2
+	tree, err := NewMutableTreeWithOpts(db.NewMemDB(), 0, &opts, false)
+	require.NoError(t, err)
+
+	for _, k := range []string{"a", "b", "c"} {
+		_, err = tree.Set([]byte(k), []byte(k))
+		require.NoError(t, err)
+	}
+	require.Equal(t, KeyMetrics{}, tree.PerKeyMetrics([]byte("a")))
+
+	// Reading b makes c the least recently accessed key.
+	_, err = tree.Get([]byte("b"))
+	require.NoError(t, err)
+	_, err = tree.Set([]byte("d"), []byte("d"))
+	require.NoError(t, err)
+	require.Equal(t, KeyMetrics{GetCount: 1, SetCount: 1}, tree.PerKeyMetrics([]byte("b")))
+	require.Equal(t, KeyMetrics{}, tree.PerKeyMetrics([]byte("c")))
+	require.Equal(t, KeyMetrics{SetCount: 1}, tree.PerKeyMetrics([]byte("d")))
+}
+
+func TestMutableTree_PerKeyMetrics_Disabled(t *testing.T) {
+	opts := DefaultOptions()
+	opts.KeyMetricsSize = -1
+	tree, err := NewMutableTreeWithOpts(db.NewMemDB(), 0, &opts, false)
+	require.NoError(t, err)
+
+	_, err = tree.Set([]byte("a"), []byte("a"))
+	require.NoError(t, err)
+	_, err = tree.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, KeyMetrics{}, tree.PerKeyMetrics([]byte("a")))
+	tree.ResetKeyMetrics()
+}
+
+func TestMutableTree_PerKeyMetrics_OnlyAccessedKey(t *testing.T) {
+	memDB := db.NewMemDB()
+	tree, err := NewMutableTree(memDB, 0, true)
+	require.NoError(t, err)
+	for _, k := range []string{"a", "c"} {
+		_, err = tree.Set([]byte(k), []byte(k))
+		require.NoError(t, err)
+	}
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+
+	// Setting b loads the leaves of its neighbours, which doesn't count as accessing them.
+	reloaded, err := NewMutableTree(memDB, 0, true)
+	require.NoError(t, err)
+	_, err = reloaded.Load()
+	require.NoError(t, err)
+	_, err = reloaded.Set([]byte("b"), []byte("b"))
+	require.NoError(t, err)
+	require.Equal(t, KeyMetrics{}, reloaded.PerKeyMetrics([]byte("a")))
+	require.Equal(t, KeyMetrics{}, reloaded.PerKeyMetrics([]byte("c")))
+	require.Equal(t, KeyMetrics{SetCount: 1}, reloaded.PerKeyMetrics([]byte("b")))
+}
@@ -1,11 +1,15 @@
 package iavl
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"sort"
 	"sync"
+	"unicode/utf8"
 
 	dbm "github.com/cosmos/cosmos-db"
 	"github.com/pkg/errors"
@@ -20,6 +24,36 @@ var commitGap uint64 = 5000000
 // ErrVersionDoesNotExist is returned if a requested version does not exist.
 var ErrVersionDoesNotExist = errors.New("version does not exist")
 
+// maxVersionCommentLength is the maximum length of a version comment, in bytes.
+const maxVersionCommentLength = 1024
+
+// ErrKeyConflict is returned when a key which must not exist in the tree is found, e.g. when
+// absorbing a key which already exists in the working tree.
+var ErrKeyConflict = errors.New("key already exists")
+
+// ErrKeyExists is returned when renaming a key to one which already exists. It is the same error
+// as ErrKeyConflict, so errors.Is matches either.
+var ErrKeyExists = ErrKeyConflict
+
+// ErrKeyNotFound is returned when a key which must exist in the tree is not found.
+var ErrKeyNotFound = errors.New("key not found")
+
+// ErrHashMismatch is returned when a batch does not result in the expected root hash.
+var ErrHashMismatch = errors.New("root hash mismatch")
+
+// ErrValueTooLarge is returned when a value exceeds the maximum allowed size.
+var ErrValueTooLarge = errors.New("value too large")
+
+// ErrVersionConflict is returned when saving a version which already exists.
+var ErrVersionConflict = errors.New("version already exists")
+
+// ErrPruneFailed is returned along with the saved hash and version when a version was saved, but
+// the oldest versions beyond the maximum version count could not be deleted.
+var ErrPruneFailed = errors.New("failed to delete versions beyond the maximum version count")
+
+// ErrTagNotFound is returned when a version tag does not exist.
+var ErrTagNotFound = errors.New("version tag not found")
+
 // MutableTree is a persistent tree which keeps track of versions. It is not safe for concurrent
 // use, and should be guarded by a Mutex or RWLock as appropriate. An immutable tree at a given
 // version can be returned via GetImmutable, which is safe for concurrent access.
@@ -31,6 +65,7 @@ var ErrVersionDoesNotExist = errors.New("version does not exist")
 type MutableTree struct {
 	*ImmutableTree                                     // The current, working tree.
 	lastSaved                *ImmutableTree            // The most recently saved tree.
+	concurrent               *ConcurrentImmutableTree  // The most recently saved tree, for concurrent readers.
 	orphans                  map[string]int64          // Nodes removed by changes to working tree.
 	versions                 map[int64]bool            // The previous, saved versions of the tree.
 	allRootLoaded            bool                      // Whether all roots are loaded or not(by LazyLoadVersion)
@@ -38,6 +73,11 @@ type MutableTree struct {
 	unsavedFastNodeRemovals  map[string]interface{}    // FastNodes that have not yet been removed from disk
 	ndb                      *nodeDB
 	skipFastStorageUpgrade   bool // If true, the tree will work like no fast storage and always not upgrade fast storage
+	maxVersionCount          int  // If positive, SaveVersion deletes the oldest versions beyond this count
+
+	unsavedEvictionCallbacks map[string]func(key, value []byte) // Eviction callbacks of keys set by SetWithCallback, registered once saved
+	rootWatchers             []chan []byte                      // Channels sent the root hash of each saved version, see WatchRoot
+	droppedRootEvents        int64                              // Root hashes not sent to watchers which were not ready to receive
 
 	mtx sync.Mutex
 }
@@ -51,10 +91,12 @@ func NewMutableTree(db dbm.DB, cacheSize int, skipFastStorageUpgrade bool) (*Mut
 func NewMutableTreeWithOpts(db dbm.DB, cacheSize int, opts *Options, skipFastStorageUpgrade bool) (*MutableTree, error) {
 	ndb := newNodeDB(db, cacheSize, opts)
 	head := &ImmutableTree{ndb: ndb, skipFastStorageUpgrade: skipFastStorageUpgrade}
+	lastSaved := head.clone()
 
 	return &MutableTree{
 		ImmutableTree:            head,
-		lastSaved:                head.clone(),
+		lastSaved:                lastSaved,
+		concurrent:               newConcurrentImmutableTree(lastSaved),
 		orphans:                  map[string]int64{},
 		versions:                 map[int64]bool{},
 		allRootLoaded:            false,
@@ -65,13 +107,22 @@ func NewMutableTreeWithOpts(db dbm.DB, cacheSize int, opts *Options, skipFastSto
 	}, nil
 }
 
+// ConcurrentVersion returns a view of the latest saved version which is safe for concurrent
+// reads. The view follows the tree: each time a new version is saved or loaded, it is
+// atomically switched over to that version.
+func (tree *MutableTree) ConcurrentVersion() *ConcurrentImmutableTree {
+	return tree.concurrent
+}
+
 // IsEmpty returns whether or not the tree has any keys. Only trees that are
 // not empty can be saved.
 func (tree *MutableTree) IsEmpty() bool {
 	return tree.ImmutableTree.Size() == 0
 }
 
-// VersionExists returns whether or not a version exists.
+// VersionExists returns whether or not a version exists. It only consults the version index,
+// without loading any nodes, and is cheap enough to be used as a fast path before loading a
+// version.
 func (tree *MutableTree) VersionExists(version int64) bool {
 	tree.mtx.Lock()
 	defer tree.mtx.Unlock()
@@ -115,6 +166,89 @@ func (tree *MutableTree) WorkingHash() ([]byte, error) {
 	return tree.ImmutableTree.Hash()
 }
 
+// HashWithoutKeys returns the hash the working tree would have if the given keys were removed
+// from it. The tree itself is not modified.
+func (tree *MutableTree) HashWithoutKeys(excludeKeys [][]byte) ([]byte, error) {
+	overlay := tree.overlay()
+	for _, key := range excludeKeys {
+		if _, _, err := overlay.Remove(key); err != nil {
+			return nil, err
+		}
+	}
+	return overlay.WorkingHash()
+}
+
+// ExpectedRootAfterDelete returns the hash the working tree would have if key were removed
+// from it, or ErrKeyNotFound if the key does not exist. The tree itself is not modified.
+func (tree *MutableTree) ExpectedRootAfterDelete(key []byte) ([]byte, error) {
+	overlay := tree.overlay()
+	_, removed, err := overlay.Remove(key)
+	if err != nil {
+		return nil, err
+	}
+	if !removed {
+		return nil, errors.Wrapf(ErrKeyNotFound, "key %X", key)
+	}
+	return overlay.WorkingHash()
+}
+
+// RootHashAfterApply returns the hash the working tree would have after applying ops in order,
+// e.g. to predict the root hash of a block before executing it. The ops are applied to a scratch
+// copy of the tree, which is then discarded: the tree itself is not modified.
+func (tree *MutableTree) RootHashAfterApply(ops []KVOp) ([]byte, error) {
+	overlay := tree.overlay()
+	for _, op := range ops {
+		var err error
+		if op.Delete {
+			_, _, err = overlay.Remove(op.Key)
+		} else {
+			// set rather than Set, so that predicted changes are not counted in the key metrics.
+			_, _, err = overlay.set(op.Key, op.Value)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return overlay.WorkingHash()
+}
+
+// overlay returns a scratch copy of the working tree. Since changes are copy-on-write, the copy
+// can be modified without affecting the tree. It shares the tree's node database, but has no
+// fast node index, and must never be saved.
+func (tree *MutableTree) overlay() *MutableTree {
+	return &MutableTree{
+		ImmutableTree: &ImmutableTree{
+			root:                   tree.root,
+			ndb:                    tree.ndb,
+			version:                tree.version,
+			skipFastStorageUpgrade: true,
+		},
+		lastSaved:                tree.lastSaved,
+		orphans:                  map[string]int64{},
+		versions:                 map[int64]bool{},
+		unsavedFastNodeAdditions: make(map[string]*fastnode.Node),
+		unsavedFastNodeRemovals:  make(map[string]interface{}),
+		ndb:                      tree.ndb,
+		skipFastStorageUpgrade:   true,
+	}
+}
+
+// ObserveHashes sets an observer which is called synchronously with the hash of every inner
+// node computed by the tree, along with the hashes of its children. A nil observer disables
+// the hook. It must not be called concurrently with other tree operations.
+func (tree *MutableTree) ObserveHashes(observer func(nodeHash, leftHash, rightHash []byte)) {
+	tree.ndb.hashObserver = observer
+}
+
+// SetEvictionListener sets a listener which is called synchronously with the hash and node of
+// every node evicted from the node cache, e.g. to invalidate secondary indexes. A nil listener
+// disables the callback. The listener is called once the node database is unlocked, but must not
+// call back into the tree, and must not modify the node. It must not be set concurrently with
+// other tree operations.
+func (tree *MutableTree) SetEvictionListener(listener func(key []byte, node *Node)) {
+	tree.ndb.evictionListener = listener
+}
+
 // String returns a string representation of the tree.
 func (tree *MutableTree) String() (string, error) {
 	return tree.ndb.String()
@@ -136,6 +270,8 @@ func (tree *MutableTree) Set(key, value []byte) (updated bool, err error) {
 	if err != nil {
 		return false, err
 	}
+	delete(tree.unsavedEvictionCallbacks, unsafeToStr(key))
+	tree.ndb.keyMetrics.recordSet(key)
 	err = tree.addOrphans(orphaned)
 	if err != nil {
 		return updated, err
@@ -143,12 +279,63 @@ func (tree *MutableTree) Set(key, value []byte) (updated bool, err error) {
 	return updated, nil
 }
 
+// SetWithCallback is like Set, but once the key is saved, onEvict is called with the key and
+// value when the node holding them is evicted from the node cache, e.g. to copy the value to a
+// secondary store. The callback is called at most once, and is dropped if the key is changed
+// again before saving, or if the node is deleted. If the node is no longer cached once saved, e.g.
+// with the node cache disabled, it is called right after saving. It is kept in a map by node hash
+// alongside the cache, and is called synchronously once the node database is unlocked, so it must
+// not call back into the tree.
+func (tree *MutableTree) SetWithCallback(key, value []byte, onEvict func(key, value []byte)) error {
+	if _, err := tree.Set(key, value); err != nil {
+		return err
+	}
+	if tree.unsavedEvictionCallbacks == nil {
+		tree.unsavedEvictionCallbacks = map[string]func(key, value []byte){}
+	}
+	tree.unsavedEvictionCallbacks[string(key)] = onEvict
+	return nil
+}
+
+// unsavedEvictionLeaves returns the unsaved leaf nodes of the keys set by SetWithCallback, along
+// with their callbacks. It must be called before the working tree is saved, while the unsaved
+// nodes are still linked to their children.
+func (tree *MutableTree) unsavedEvictionLeaves() map[*Node]func(key, value []byte) {
+	leaves := make(map[*Node]func(key, value []byte), len(tree.unsavedEvictionCallbacks))
+	for key, onEvict := range tree.unsavedEvictionCallbacks {
+		// A path to an unsaved leaf only goes through unsaved nodes.
+		node := tree.root
+		for node != nil && !node.persisted && !node.isLeaf() {
+			if key < unsafeToStr(node.key) {
+				node = node.leftNode
+			} else {
+				node = node.rightNode
+			}
+		}
+		if node == nil || node.persisted || unsafeToStr(node.key) != key {
+			continue
+		}
+		leaves[node] = onEvict
+	}
+	return leaves
+}
+
+// registerEvictionCallbacks registers the callbacks of the given leaves, once they are saved.
+func (tree *MutableTree) registerEvictionCallbacks(leaves map[*Node]func(key, value []byte)) {
+	for leaf, onEvict := range leaves {
+		tree.ndb.setEvictionCallback(leaf, onEvict)
+	}
+	tree.unsavedEvictionCallbacks = nil
+}
+
 // Get returns the value of the specified key if it exists, or nil otherwise.
 // The returned value must not be modified, since it may point to data stored within IAVL.
 func (tree *MutableTree) Get(key []byte) ([]byte, error) {
 	if tree.root == nil {
 		return nil, nil
 	}
+	tree.ndb.hotKeys.record(key)
+	tree.ndb.keyMetrics.recordGet(key)
 
 	if !tree.skipFastStorageUpgrade {
 		if fastNode, ok := tree.unsavedFastNodeAdditions[unsafeToStr(key)]; ok {
@@ -160,7 +347,176 @@ func (tree *MutableTree) Get(key []byte) ([]byte, error) {
 		}
 	}
 
-	return tree.ImmutableTree.Get(key)
+	return tree.ImmutableTree.get(key)
+}
+
+// GetOrDefault is like Get, but returns defaultValue if the key does not exist.
+func (tree *MutableTree) GetOrDefault(key, defaultValue []byte) ([]byte, error) {
+	value, err := tree.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return defaultValue, nil
+	}
+	return value, nil
+}
+
+// Has returns whether or not a key exists in the working tree. Unlike Get, it only walks the
+// tree down to the key, without reading values from the fast node index.
+func (tree *MutableTree) Has(key []byte) (bool, error) {
+	if tree.root == nil {
+		return false, nil
+	}
+
+	if !tree.skipFastStorageUpgrade {
+		if _, ok := tree.unsavedFastNodeAdditions[unsafeToStr(key)]; ok {
+			return true, nil
+		}
+		if _, ok := tree.unsavedFastNodeRemovals[unsafeToStr(key)]; ok {
+			return false, nil
+		}
+	}
+
+	return tree.ImmutableTree.Has(key)
+}
+
+// GetAndTouch is like Get, but also marks the nodes on the path to the key as most recently used
+// in the node cache, so that they are the last to be evicted.
+func (tree *MutableTree) GetAndTouch(key []byte) ([]byte, error) {
+	value, err := tree.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := tree.ImmutableTree.touchPath(key); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// PersistenceStats returns the number of reads and writes made to the database by the tree, and
+// by the immutable trees of its versions, since it was created or since ResetPersistenceStats was
+// last called.
+func (tree *MutableTree) PersistenceStats() PersistenceStats {
+	return tree.ndb.dbStats.stats()
+}
+
+// ResetPersistenceStats resets the counters returned by PersistenceStats to zero.
+func (tree *MutableTree) ResetPersistenceStats() {
+	tree.ndb.dbStats.reset()
+}
+
+// RecordCacheAccesses writes the hash of each node read from the node database to writer, hex
+// encoded, one per line, until the returned function is called. The output can be passed to
+// CacheWarmup after a restart. Writes are buffered, and flushed by the returned function; a write
+// error ends the recording. Only one recording may be active at a time.
+func (tree *MutableTree) RecordCacheAccesses(writer io.Writer) (stop func()) {
+	var mtx sync.Mutex
+	buf := bufio.NewWriter(writer)
+	stopped := false
+	tree.ndb.setAccessRecorder(func(hash []byte) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if stopped {
+			return
+		}
+		if _, err := fmt.Fprintf(buf, "%x\n", hash); err != nil {
+			stopped = true
+		}
+	})
+	return func() {
+		tree.ndb.setAccessRecorder(nil)
+		mtx.Lock()
+		defer mtx.Unlock()
+		if !stopped {
+			stopped = true
+			buf.Flush() //nolint:errcheck
+		}
+	}
+}
+
+// CacheWarmup loads the nodes whose hashes are read from reader into the node cache, in order,
+// e.g. to warm up the cache after a restart with the output of RecordCacheAccesses. Hashes must
+// be hex encoded, one per line. Blank lines, and nodes which no longer exist, are skipped.
+func (tree *MutableTree) CacheWarmup(reader io.Reader) error {
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		hash := make([]byte, hex.DecodedLen(len(text)))
+		if _, err := hex.Decode(hash, text); err != nil {
+			return errors.Wrapf(err, "invalid node hash on line %d", line)
+		}
+		if _, err := tree.ndb.GetNode(hash); err != nil && !errors.Is(err, errNodeMissing) {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// HotKeys returns the up to n most frequently read keys, most frequent first. Access counts
+// are approximate once more distinct keys have been read than Options.HotKeyTracking. Returns
+// ErrHotKeyTrackingDisabled if Options.HotKeyTracking is not set.
+func (tree *MutableTree) HotKeys(n int) ([][]byte, error) {
+	return tree.ndb.hotKeys.top(n)
+}
+
+// ResetHotKeys clears all key access counts.
+func (tree *MutableTree) ResetHotKeys() {
+	tree.ndb.hotKeys.reset()
+}
+
+// PerKeyMetrics returns the access metrics of a key since the tree was created, or since
+// ResetKeyMetrics was last called. Metrics are kept for the Options.KeyMetricsSize most recently
+// accessed keys, and are zero for other keys, or if tracking is disabled.
+func (tree *MutableTree) PerKeyMetrics(key []byte) KeyMetrics {
+	return tree.ndb.keyMetrics.get(key)
+}
+
+// ResetKeyMetrics clears the access metrics of all keys.
+func (tree *MutableTree) ResetKeyMetrics() {
+	tree.ndb.keyMetrics.reset()
+}
+
+// AbsorbImmutable sets all key/value pairs of t in the working tree. Unless force is true,
+// ErrKeyConflict is returned if any of the keys already exists in the working tree, including
+// unsaved changes. On any error, the working tree is left unchanged.
+func (tree *MutableTree) AbsorbImmutable(t *ImmutableTree, force bool) error {
+	if t.root == nil {
+		return nil
+	}
+	itr, err := t.Iterator(nil, nil, true)
+	if err != nil {
+		return err
+	}
+	defer itr.Close()
+
+	checkpoint := tree.checkpoint()
+	for ; itr.Valid(); itr.Next() {
+		if !force {
+			has, err := tree.Has(itr.Key())
+			if err != nil {
+				tree.restore(checkpoint)
+				return err
+			}
+			if has {
+				tree.restore(checkpoint)
+				return errors.Wrapf(ErrKeyConflict, "key %X", itr.Key())
+			}
+		}
+		if _, err := tree.Set(itr.Key(), itr.Value()); err != nil {
+			tree.restore(checkpoint)
+			return err
+		}
+	}
+	// A node which fails to load ends the iteration, so it must be checked for errors.
+	if err := itr.Error(); err != nil {
+		tree.restore(checkpoint)
+		return err
+	}
+	return nil
 }
 
 // Import returns an importer for tree nodes previously exported by ImmutableTree.Export(),
@@ -221,6 +577,17 @@ func (tree *MutableTree) Iterator(start, end []byte, ascending bool) (dbm.Iterat
 	return tree.ImmutableTree.Iterator(start, end, ascending)
 }
 
+// FilteredIterator returns an iterator over the domain of keys, which only yields the
+// entries for which predicate returns true. Note that every entry between start and end
+// is still visited, matching or not.
+func (tree *MutableTree) FilteredIterator(start, end []byte, ascending bool, predicate func(key, value []byte) bool) (dbm.Iterator, error) {
+	itr, err := tree.Iterator(start, end, ascending)
+	if err != nil {
+		return nil, err
+	}
+	return NewFilteredIterator(itr, predicate), nil
+}
+
 func (tree *MutableTree) set(key []byte, value []byte) (orphans []*Node, updated bool, err error) {
 	if value == nil {
 		return nil, updated, fmt.Errorf("attempt to store nil value at key '%s'", key)
@@ -317,6 +684,171 @@ func (tree *MutableTree) recursiveSet(node *Node, key []byte, value []byte, orph
 	}
 }
 
+// SetFromReader sets a key in the working tree to the value read from r, as in Set(). At most
+// maxSize bytes are read, and ErrValueTooLarge is returned without modifying the tree if r
+// yields more data.
+func (tree *MutableTree) SetFromReader(key []byte, r io.Reader, maxSize int64) (updated bool, err error) {
+	if maxSize < 0 {
+		return false, errors.Errorf("maximum value size must be non-negative, got %d", maxSize)
+	}
+	value, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return false, errors.Wrapf(err, "reading value of key %X", key)
+	}
+	if int64(len(value)) > maxSize {
+		return false, errors.Wrapf(ErrValueTooLarge, "value of key %X exceeds %d bytes", key, maxSize)
+	}
+	return tree.Set(key, value)
+}
+
+// KVPair is a key/value pair.
+type KVPair struct {
+	Key   []byte
+	Value []byte
+}
+
+// AtomicCompareAndSwapAll applies the changes in newState to the working tree, but only if the
+// value of every key in expected matches the expected value, where a nil value means the key
+// must not exist. In newState, a nil value removes the key. If any check fails, or if applying
+// the changes fails, the tree is not modified and false is returned.
+func (tree *MutableTree) AtomicCompareAndSwapAll(expected, newState []KVPair) (swapped bool, err error) {
+	for _, pair := range expected {
+		if pair.Value == nil {
+			has, err := tree.Has(pair.Key)
+			if err != nil || has {
+				return false, err
+			}
+			continue
+		}
+		value, err := tree.Get(pair.Key)
+		if err != nil || value == nil || !bytes.Equal(value, pair.Value) {
+			return false, err
+		}
+	}
+
+	checkpoint := tree.checkpoint()
+	for _, pair := range newState {
+		if pair.Value == nil {
+			_, _, err = tree.Remove(pair.Key)
+		} else {
+			_, err = tree.Set(pair.Key, pair.Value)
+		}
+		if err != nil {
+			tree.restore(checkpoint)
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// KVOp is a change to a single key: either setting its value, or deleting the key.
+type KVOp struct {
+	Key    []byte
+	Value  []byte
+	Delete bool
+}
+
+// CommitBatch applies ops to the working tree in order, and saves a new version if the resulting
+// root hash equals expectedRootHash. Otherwise, all operations are rolled back, and the actual
+// root hash is returned along with ErrHashMismatch. Any unsaved changes made before the call are
+// kept, and are saved with the new version.
+func (tree *MutableTree) CommitBatch(ops []KVOp, expectedRootHash []byte) (actualRoot []byte, err error) {
+	checkpoint := tree.checkpoint()
+	for _, op := range ops {
+		if op.Delete {
+			_, _, err = tree.Remove(op.Key)
+		} else {
+			_, err = tree.Set(op.Key, op.Value)
+		}
+		if err != nil {
+			tree.restore(checkpoint)
+			return nil, err
+		}
+	}
+
+	actualRoot, err = tree.WorkingHash()
+	if err != nil {
+		tree.restore(checkpoint)
+		return nil, err
+	}
+	if !bytes.Equal(actualRoot, expectedRootHash) {
+		tree.restore(checkpoint)
+		return actualRoot, errors.Wrapf(ErrHashMismatch, "expected %X, got %X", expectedRootHash, actualRoot)
+	}
+
+	actualRoot, _, err = tree.SaveVersion()
+	return actualRoot, err
+}
+
+// AtomicRename moves the value of oldKey to newKey in the working tree, removing oldKey. Returns
+// ErrKeyNotFound if oldKey does not exist, or ErrKeyExists if newKey already exists, in which
+// case the tree is left unchanged. Unlike a Get, Remove and Set, it only walks the tree twice.
+func (tree *MutableTree) AtomicRename(oldKey, newKey []byte) error {
+	checkpoint := tree.checkpoint()
+	value, removed, err := tree.Remove(oldKey)
+	if err != nil {
+		tree.restore(checkpoint)
+		return err
+	}
+	if !removed {
+		return errors.Wrapf(ErrKeyNotFound, "key %X", oldKey)
+	}
+	updated, err := tree.Set(newKey, value)
+	if err != nil || updated {
+		tree.restore(checkpoint)
+	}
+	if err != nil {
+		return err
+	}
+	if updated {
+		return errors.Wrapf(ErrKeyExists, "key %X", newKey)
+	}
+	return nil
+}
+
+// workingState holds the unsaved changes of a working tree, see checkpoint().
+type workingState struct {
+	root                     *Node
+	orphans                  map[string]int64
+	unsavedFastNodeAdditions map[string]*fastnode.Node
+	unsavedFastNodeRemovals  map[string]interface{}
+	unsavedEvictionCallbacks map[string]func(key, value []byte)
+}
+
+// checkpoint returns the current unsaved changes of the working tree, which can be reverted to
+// with restore(). Since changes are copy-on-write, only the root and bookkeeping maps are kept.
+func (tree *MutableTree) checkpoint() workingState {
+	state := workingState{
+		root:                     tree.root,
+		orphans:                  make(map[string]int64, len(tree.orphans)),
+		unsavedFastNodeAdditions: make(map[string]*fastnode.Node, len(tree.unsavedFastNodeAdditions)),
+		unsavedFastNodeRemovals:  make(map[string]interface{}, len(tree.unsavedFastNodeRemovals)),
+		unsavedEvictionCallbacks: make(map[string]func(key, value []byte), len(tree.unsavedEvictionCallbacks)),
+	}
+	for k, v := range tree.orphans {
+		state.orphans[k] = v
+	}
+	for k, v := range tree.unsavedFastNodeAdditions {
+		state.unsavedFastNodeAdditions[k] = v
+	}
+	for k, v := range tree.unsavedFastNodeRemovals {
+		state.unsavedFastNodeRemovals[k] = v
+	}
+	for k, v := range tree.unsavedEvictionCallbacks {
+		state.unsavedEvictionCallbacks[k] = v
+	}
+	return state
+}
+
+// restore reverts the working tree to a checkpoint.
+func (tree *MutableTree) restore(state workingState) {
+	tree.root = state.root
+	tree.orphans = state.orphans
+	tree.unsavedFastNodeAdditions = state.unsavedFastNodeAdditions
+	tree.unsavedFastNodeRemovals = state.unsavedFastNodeRemovals
+	tree.unsavedEvictionCallbacks = state.unsavedEvictionCallbacks
+}
+
 // Remove removes a key from the working tree. The given key byte slice should not be modified
 // after this call, since it may point to data stored inside IAVL.
 func (tree *MutableTree) Remove(key []byte) ([]byte, bool, error) {
@@ -332,6 +864,47 @@ func (tree *MutableTree) Remove(key []byte) ([]byte, bool, error) {
 	return val, removed, nil
 }
 
+// MassDelete removes all keys from the working tree for which predicate returns true, in a
+// single pass over the tree. Returns the number of removed keys.
+func (tree *MutableTree) MassDelete(predicate func(key, value []byte) bool) (deleted int, err error) {
+	return tree.removeRange(nil, nil, predicate)
+}
+
+// TruncateKeysWithPrefix removes all keys with the given prefix from the working tree, in a
+// single sorted pass over the prefix range. Returns the number of removed keys.
+func (tree *MutableTree) TruncateKeysWithPrefix(prefix []byte) (deleted int, err error) {
+	return tree.removeRange(prefix, prefixEnd(prefix), func(key, value []byte) bool {
+		return true
+	})
+}
+
+// removeRange removes the keys between start (inclusive) and end (exclusive) for which
+// predicate returns true. The traversal runs over a snapshot of the working tree, which is not
+// affected by the removals since they are copy-on-write.
+func (tree *MutableTree) removeRange(start, end []byte, predicate func(key, value []byte) bool) (removed int, err error) {
+	snapshot := &ImmutableTree{
+		root:                   tree.root,
+		ndb:                    tree.ndb,
+		version:                tree.version,
+		skipFastStorageUpgrade: true,
+	}
+	_, traverseErr := snapshot.traverseLeaves(start, end, true, false, func(node *Node) bool {
+		if !predicate(node.key, node.value) {
+			return false
+		}
+		_, _, err = tree.Remove(node.key)
+		if err != nil {
+			return true
+		}
+		removed++
+		return false
+	})
+	if traverseErr != nil {
+		return removed, traverseErr
+	}
+	return removed, err
+}
+
 // remove tries to remove a key from the tree and if removed, returns its
 // value, nodes orphaned and 'true'.
 func (tree *MutableTree) remove(key []byte) (value []byte, orphaned []*Node, removed bool, err error) {
@@ -500,6 +1073,10 @@ func (tree *MutableTree) LazyLoadVersion(targetVersion int64) (int64, error) {
 	if rootHash == nil {
 		return latestVersion, ErrVersionDoesNotExist
 	}
+	skipFastStorage, err := tree.skipFastStorageAt(targetVersion)
+	if err != nil {
+		return 0, err
+	}
 
 	tree.mtx.Lock()
 	defer tree.mtx.Unlock()
@@ -509,7 +1086,7 @@ func (tree *MutableTree) LazyLoadVersion(targetVersion int64) (int64, error) {
 	iTree := &ImmutableTree{
 		ndb:                    tree.ndb,
 		version:                targetVersion,
-		skipFastStorageUpgrade: tree.skipFastStorageUpgrade,
+		skipFastStorageUpgrade: skipFastStorage,
 	}
 	if len(rootHash) > 0 {
 		// If rootHash is empty then root of tree should be nil
@@ -523,6 +1100,7 @@ func (tree *MutableTree) LazyLoadVersion(targetVersion int64) (int64, error) {
 	tree.orphans = map[string]int64{}
 	tree.ImmutableTree = iTree
 	tree.lastSaved = iTree.clone()
+	tree.concurrent.publish(tree.lastSaved)
 
 	if !tree.skipFastStorageUpgrade {
 		// Attempt to upgrade
@@ -582,10 +1160,14 @@ func (tree *MutableTree) LoadVersion(targetVersion int64) (int64, error) {
 			tree.ndb.opts.InitialVersion, firstVersion)
 	}
 
+	skipFastStorage, err := tree.skipFastStorageAt(latestVersion)
+	if err != nil {
+		return 0, err
+	}
 	t := &ImmutableTree{
 		ndb:                    tree.ndb,
 		version:                latestVersion,
-		skipFastStorageUpgrade: tree.skipFastStorageUpgrade,
+		skipFastStorageUpgrade: skipFastStorage,
 	}
 
 	if len(latestRoot) != 0 {
@@ -598,6 +1180,7 @@ func (tree *MutableTree) LoadVersion(targetVersion int64) (int64, error) {
 	tree.orphans = map[string]int64{}
 	tree.ImmutableTree = t
 	tree.lastSaved = t.clone()
+	tree.concurrent.publish(tree.lastSaved)
 	tree.allRootLoaded = true
 
 	if !tree.skipFastStorageUpgrade {
@@ -737,6 +1320,10 @@ func (tree *MutableTree) enableFastStorageAndCommit() error {
 // GetImmutable loads an ImmutableTree at a given version for querying. The returned tree is
 // safe for concurrent access, provided the version is not deleted, e.g. via `DeleteVersion()`.
 func (tree *MutableTree) GetImmutable(version int64) (*ImmutableTree, error) {
+	if !tree.VersionExists(version) {
+		return nil, ErrVersionDoesNotExist
+	}
+
 	rootHash, err := tree.ndb.getRoot(version)
 	if err != nil {
 		return nil, err
@@ -744,6 +1331,10 @@ func (tree *MutableTree) GetImmutable(version int64) (*ImmutableTree, error) {
 	if rootHash == nil {
 		return nil, ErrVersionDoesNotExist
 	}
+	skipFastStorage, err := tree.skipFastStorageAt(version)
+	if err != nil {
+		return nil, err
+	}
 
 	tree.mtx.Lock()
 	defer tree.mtx.Unlock()
@@ -752,7 +1343,7 @@ func (tree *MutableTree) GetImmutable(version int64) (*ImmutableTree, error) {
 		return &ImmutableTree{
 			ndb:                    tree.ndb,
 			version:                version,
-			skipFastStorageUpgrade: tree.skipFastStorageUpgrade,
+			skipFastStorageUpgrade: skipFastStorage,
 		}, nil
 	}
 	tree.versions[version] = true
@@ -765,10 +1356,19 @@ func (tree *MutableTree) GetImmutable(version int64) (*ImmutableTree, error) {
 		root:                   root,
 		ndb:                    tree.ndb,
 		version:                version,
-		skipFastStorageUpgrade: tree.skipFastStorageUpgrade,
+		skipFastStorageUpgrade: skipFastStorage,
 	}, nil
 }
 
+// skipFastStorageAt returns whether trees of the given version must not read fast nodes, which
+// don't reflect the historical versions saved by SaveVersionAs.
+func (tree *MutableTree) skipFastStorageAt(version int64) (bool, error) {
+	if tree.skipFastStorageUpgrade {
+		return true, nil
+	}
+	return tree.ndb.isHistoricalVersion(version)
+}
+
 // Rollback resets the working tree to the latest saved version, discarding
 // any unsaved modifications.
 func (tree *MutableTree) Rollback() {
@@ -782,6 +1382,7 @@ func (tree *MutableTree) Rollback() {
 		}
 	}
 	tree.orphans = map[string]int64{}
+	tree.unsavedEvictionCallbacks = nil
 	if !tree.skipFastStorageUpgrade {
 		tree.unsavedFastNodeAdditions = map[string]*fastnode.Node{}
 		tree.unsavedFastNodeRemovals = map[string]interface{}{}
@@ -822,9 +1423,60 @@ func (tree *MutableTree) GetVersioned(key []byte, version int64) ([]byte, error)
 	return nil, nil
 }
 
+// ReadSnapshotAt calls fn with the immutable tree at the given version, and returns its error.
+// The version is registered as having an active reader while fn runs, which prevents it from
+// being deleted. The tree must not be used after fn returns. Returns ErrVersionDoesNotExist if
+// the version does not exist.
+func (tree *MutableTree) ReadSnapshotAt(version int64, fn func(t *ImmutableTree) error) error {
+	// Register the reader before opening the version, so it can't be deleted in between.
+	tree.ndb.incrVersionReaders(version)
+	defer tree.ndb.decrVersionReaders(version)
+	t, err := tree.GetImmutable(version)
+	if err != nil {
+		return err
+	}
+	return fn(t)
+}
+
+// IterateByVersion iterates over all keys of the given saved version, as in Iterate(), while
+// registered as a reader of the version. Returns ErrVersionDoesNotExist if the version does
+// not exist. Returns true if stopped by callback, false otherwise.
+func (tree *MutableTree) IterateByVersion(version int64, fn func(key []byte, value []byte) bool) (stopped bool, err error) {
+	err = tree.ReadSnapshotAt(version, func(t *ImmutableTree) error {
+		stopped, err = t.Iterate(fn)
+		return err
+	})
+	return stopped, err
+}
+
+// KeyExistsAt returns whether or not the key exists at the specified version. Returns
+// ErrVersionDoesNotExist if the version does not exist.
+func (tree *MutableTree) KeyExistsAt(key []byte, version int64) (bool, error) {
+	t, err := tree.GetImmutable(version)
+	if err != nil {
+		return false, err
+	}
+	return t.Has(key)
+}
+
 // SaveVersion saves a new tree version to disk, based on the current state of
 // the tree. Returns the hash and new version number.
+//
+// If a maximum version count is set, the oldest versions beyond it are deleted once the new
+// version is saved. If that fails, the hash and version of the saved version are returned along
+// with ErrPruneFailed: the version must not be saved again.
 func (tree *MutableTree) SaveVersion() ([]byte, int64, error) {
+	hash, version, err := tree.saveVersion()
+	if err != nil {
+		return hash, version, err
+	}
+	if err := tree.pruneToMaxVersionCount(); err != nil {
+		return hash, version, err
+	}
+	return hash, version, nil
+}
+
+func (tree *MutableTree) saveVersion() ([]byte, int64, error) {
 	version := tree.version + 1
 	if version == 1 && tree.ndb.opts.InitialVersion > 0 {
 		version = int64(tree.ndb.opts.InitialVersion)
@@ -853,46 +1505,75 @@ func (tree *MutableTree) SaveVersion() ([]byte, int64, error) {
 			tree.version = version
 			tree.ImmutableTree = tree.ImmutableTree.clone()
 			tree.lastSaved = tree.ImmutableTree.clone()
+			tree.concurrent.publish(tree.lastSaved)
 			tree.orphans = map[string]int64{}
+			tree.notifyRootWatchers(existingHash)
 			return existingHash, version, nil
 		}
 
 		return nil, version, fmt.Errorf("version %d was already saved to different hash %X (existing hash %X)", version, newHash, existingHash)
 	}
 
+	if err := tree.writeVersion(version, true); err != nil {
+		return nil, version, err
+	}
+	tree.setSavedVersion(version)
+
+	hash, err := tree.Hash()
+	if err != nil {
+		return nil, version, err
+	}
+	tree.notifyRootWatchers(hash)
+
+	return hash, version, nil
+}
+
+// writeVersion writes the working tree, its orphans and fast nodes to disk as the given version,
+// and commits. If consecutive is true, the version must follow the latest saved version.
+func (tree *MutableTree) writeVersion(version int64, consecutive bool) error {
 	if tree.root == nil {
 		// There can still be orphans, for example if the root is the node being
 		// removed.
 		logger.Debug("SAVE EMPTY TREE %v\n", version)
 		if err := tree.ndb.SaveOrphans(version, tree.orphans); err != nil {
-			return nil, 0, err
-		}
-		if err := tree.ndb.SaveEmptyRoot(version); err != nil {
-			return nil, 0, err
+			return err
 		}
 	} else {
 		logger.Debug("SAVE TREE %v\n", version)
+		leaves := tree.unsavedEvictionLeaves()
 		if _, err := tree.ndb.SaveBranch(tree.root); err != nil {
-			return nil, 0, err
+			return err
 		}
+		tree.registerEvictionCallbacks(leaves)
 		if err := tree.ndb.SaveOrphans(version, tree.orphans); err != nil {
-			return nil, 0, err
-		}
-		if err := tree.ndb.SaveRoot(tree.root, version); err != nil {
-			return nil, 0, err
+			return err
 		}
 	}
 
+	var err error
+	switch {
+	case !consecutive:
+		err = tree.ndb.SaveRootAt(tree.root, version)
+	case tree.root == nil:
+		err = tree.ndb.SaveEmptyRoot(version)
+	default:
+		err = tree.ndb.SaveRoot(tree.root, version)
+	}
+	if err != nil {
+		return err
+	}
+
 	if !tree.skipFastStorageUpgrade {
 		if err := tree.saveFastNodeVersion(); err != nil {
-			return nil, version, err
+			return err
 		}
 	}
 
-	if err := tree.ndb.Commit(); err != nil {
-		return nil, version, err
-	}
+	return tree.ndb.Commit()
+}
 
+// setSavedVersion makes the working tree the latest saved version, once it has been written.
+func (tree *MutableTree) setSavedVersion(version int64) {
 	tree.mtx.Lock()
 	defer tree.mtx.Unlock()
 	tree.version = version
@@ -901,18 +1582,239 @@ func (tree *MutableTree) SaveVersion() ([]byte, int64, error) {
 	// set new working tree
 	tree.ImmutableTree = tree.ImmutableTree.clone()
 	tree.lastSaved = tree.ImmutableTree.clone()
+	tree.concurrent.publish(tree.lastSaved)
 	tree.orphans = map[string]int64{}
 	if !tree.skipFastStorageUpgrade {
 		tree.unsavedFastNodeAdditions = make(map[string]*fastnode.Node)
 		tree.unsavedFastNodeRemovals = make(map[string]interface{})
 	}
+}
 
-	hash, err := tree.Hash()
+// SaveVersionAs saves the working tree as the given version, and returns its hash. Returns
+// ErrVersionConflict if the version already exists.
+//
+// If the version is after the latest saved version, it becomes the latest version, and any
+// versions in between are left unused. Otherwise, the working tree is saved as a historical
+// version, but is left unchanged and unsaved: it can still be saved as the next version. Nodes
+// of later versions are then copied into the historical version, so that it only references
+// nodes of the same or earlier versions, and is pruned like any other version. Since the fast
+// nodes only follow the latest version, reads of a historical version never use them.
+func (tree *MutableTree) SaveVersionAs(targetVersion int64) ([]byte, error) {
+	if targetVersion <= 0 {
+		return nil, errors.New("version must be greater than 0")
+	}
+	if tree.VersionExists(targetVersion) {
+		return nil, errors.Wrapf(ErrVersionConflict, "version %d", targetVersion)
+	}
+	if targetVersion < tree.version {
+		return tree.saveHistoricalVersion(targetVersion)
+	}
+
+	checkpoint := tree.checkpoint()
+	if err := tree.setUnsavedVersion(targetVersion); err != nil {
+		tree.restore(checkpoint)
+		return nil, err
+	}
+	hash, err := tree.WorkingHash()
 	if err != nil {
-		return nil, version, err
+		tree.restore(checkpoint)
+		return nil, err
+	}
+	if err := tree.writeVersion(targetVersion, false); err != nil {
+		tree.restore(checkpoint)
+		return nil, err
 	}
+	tree.setSavedVersion(targetVersion)
+	tree.notifyRootWatchers(hash)
+	if err := tree.pruneToMaxVersionCount(); err != nil {
+		return hash, err
+	}
+	return hash, nil
+}
 
-	return hash, version, nil
+// saveHistoricalVersion saves the working tree as the given version, which is before the latest
+// one, without changing the working tree. The nodes copied for the version are saved as orphans
+// living only in it, so that they are deleted along with it.
+func (tree *MutableTree) saveHistoricalVersion(version int64) ([]byte, error) {
+	var copies []*Node
+	root, err := tree.versionedCopy(tree.root, version, &copies)
+	if err != nil {
+		return nil, err
+	}
+	hash, _, err := root.hashWithCount(tree.hashObserver())
+	if err != nil {
+		return nil, err
+	}
+
+	// A copy may be identical to a node still used by other versions, e.g. if the version was
+	// deleted before. Such a node is already deleted with the last version using it.
+	orphans := make(map[string]int64, len(copies))
+	for _, node := range copies {
+		exists, err := tree.ndb.Has(node.hash)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			orphans[string(node.hash)] = version
+		}
+	}
+	if root != nil {
+		if _, err := tree.ndb.SaveBranch(root); err != nil {
+			return nil, err
+		}
+	}
+	if err := tree.ndb.SaveOrphansUntil(version, orphans); err != nil {
+		return nil, err
+	}
+	if err := tree.ndb.SaveRootAt(root, version); err != nil {
+		return nil, err
+	}
+	if err := tree.ndb.SaveHistoricalVersion(version); err != nil {
+		return nil, err
+	}
+	if err := tree.ndb.Commit(); err != nil {
+		return nil, err
+	}
+
+	tree.mtx.Lock()
+	tree.versions[version] = true
+	tree.mtx.Unlock()
+	if err := tree.pruneToMaxVersionCount(); err != nil {
+		return hash, err
+	}
+	return hash, nil
+}
+
+// versionedCopy returns the subtree of node to save as the given version. Saved nodes of the same
+// or earlier versions are reused, and other nodes are copied with the given version, and
+// appended to copies if set. The subtree itself is left unchanged.
+func (tree *MutableTree) versionedCopy(node *Node, version int64, copies *[]*Node) (*Node, error) {
+	if node == nil || (node.persisted && node.version <= version) {
+		return node, nil
+	}
+	copied := &Node{
+		key:           node.key,
+		value:         node.value,
+		version:       version,
+		size:          node.size,
+		subtreeHeight: node.subtreeHeight,
+	}
+	if !node.isLeaf() {
+		leftNode, err := node.getLeftNode(tree.ImmutableTree)
+		if err != nil {
+			return nil, err
+		}
+		if copied.leftNode, err = tree.versionedCopy(leftNode, version, copies); err != nil {
+			return nil, err
+		}
+		rightNode, err := node.getRightNode(tree.ImmutableTree)
+		if err != nil {
+			return nil, err
+		}
+		if copied.rightNode, err = tree.versionedCopy(rightNode, version, copies); err != nil {
+			return nil, err
+		}
+	}
+	if copies != nil {
+		*copies = append(*copies, copied)
+	}
+	return copied, nil
+}
+
+// setUnsavedVersion sets the version of all unsaved nodes and fast nodes of the working tree,
+// which are created with the version following the latest one. The unsaved nodes are replaced by
+// copies, so that the previous working tree can be restored from a checkpoint.
+func (tree *MutableTree) setUnsavedVersion(version int64) error {
+	root, err := tree.versionedCopy(tree.root, version, nil)
+	if err != nil {
+		return err
+	}
+	tree.root = root
+
+	fastNodeAdditions := make(map[string]*fastnode.Node, len(tree.unsavedFastNodeAdditions))
+	for key, fastNode := range tree.unsavedFastNodeAdditions {
+		fastNodeAdditions[key] = fastnode.NewNode(fastNode.GetKey(), fastNode.GetValue(), version)
+	}
+	tree.unsavedFastNodeAdditions = fastNodeAdditions
+	return nil
+}
+
+// WatchRoot returns a channel which is sent the root hash of each new latest version once it is
+// saved. The channel is unbuffered, and hashes are only sent if the receiver is ready: otherwise,
+// they are dropped and counted by DroppedRootEvents. The hashes must not be modified.
+func (tree *MutableTree) WatchRoot() <-chan []byte {
+	tree.mtx.Lock()
+	defer tree.mtx.Unlock()
+	ch := make(chan []byte)
+	tree.rootWatchers = append(tree.rootWatchers, ch)
+	return ch
+}
+
+// UnwatchRoot stops sending root hashes to a channel returned by WatchRoot, and closes it.
+func (tree *MutableTree) UnwatchRoot(ch <-chan []byte) {
+	tree.mtx.Lock()
+	defer tree.mtx.Unlock()
+	for i, watcher := range tree.rootWatchers {
+		if watcher == ch {
+			close(watcher)
+			tree.rootWatchers = append(tree.rootWatchers[:i], tree.rootWatchers[i+1:]...)
+			return
+		}
+	}
+}
+
+// DroppedRootEvents returns the number of root hashes which were not sent to a channel returned
+// by WatchRoot, because its receiver was not ready.
+func (tree *MutableTree) DroppedRootEvents() int64 {
+	tree.mtx.Lock()
+	defer tree.mtx.Unlock()
+	return tree.droppedRootEvents
+}
+
+// notifyRootWatchers sends the root hash of a saved version to the channels returned by
+// WatchRoot, without blocking.
+func (tree *MutableTree) notifyRootWatchers(hash []byte) {
+	tree.mtx.Lock()
+	defer tree.mtx.Unlock()
+	for _, watcher := range tree.rootWatchers {
+		select {
+		case watcher <- hash:
+		default:
+			tree.droppedRootEvents++
+		}
+	}
+}
+
+// SetMaxVersionCount sets the maximum number of versions kept by the tree. Each time a version
+// is saved, the oldest versions beyond n are deleted. A value of 0 means unlimited, which is the
+// default.
+func (tree *MutableTree) SetMaxVersionCount(n int) {
+	if n < 0 {
+		n = 0
+	}
+	tree.maxVersionCount = n
+}
+
+// pruneToMaxVersionCount deletes the oldest versions until at most maxVersionCount remain.
+// Versions are read from the database, since the tree may not know all of them once lazily
+// loaded. Returns ErrPruneFailed if any version could not be deleted.
+func (tree *MutableTree) pruneToMaxVersionCount() error {
+	if tree.maxVersionCount <= 0 {
+		return nil
+	}
+	versions, err := tree.ndb.getVersions()
+	if err != nil {
+		return errors.Wrapf(ErrPruneFailed, "%v", err)
+	}
+	if len(versions) <= tree.maxVersionCount {
+		return nil
+	}
+	for _, version := range versions[:len(versions)-tree.maxVersionCount] {
+		if err := tree.DeleteVersion(version); err != nil {
+			return errors.Wrapf(ErrPruneFailed, "version %d: %v", version, err)
+		}
+	}
+	return nil
 }
 
 func (tree *MutableTree) saveFastNodeVersion() error {
@@ -995,6 +1897,101 @@ func (tree *MutableTree) deleteVersion(version int64) error {
 	return nil
 }
 
+// SetVersionComment attaches a comment to a saved version, replacing any previous comment. The
+// comment must be valid UTF-8 of at most 1024 bytes, and is deleted along with the version.
+func (tree *MutableTree) SetVersionComment(version int64, comment string) error {
+	if len(comment) > maxVersionCommentLength {
+		return errors.Errorf("version comment must be at most %d bytes, got %d", maxVersionCommentLength, len(comment))
+	}
+	if !utf8.ValidString(comment) {
+		return errors.New("version comment must be valid UTF-8")
+	}
+	if !tree.VersionExists(version) {
+		return ErrVersionDoesNotExist
+	}
+	if err := tree.ndb.SaveVersionComment(version, comment); err != nil {
+		return err
+	}
+	return tree.ndb.Commit()
+}
+
+// GetVersionComment returns the comment attached to a saved version, or an empty string if it
+// has none.
+func (tree *MutableTree) GetVersionComment(version int64) (string, error) {
+	if !tree.VersionExists(version) {
+		return "", ErrVersionDoesNotExist
+	}
+	return tree.ndb.getVersionComment(version)
+}
+
+// TagVersion names a saved version with a unique tag, which can be resolved with
+// GetVersionByTag(). A version can only have one tag: to change it, the tag must first be removed
+// with UntagVersion(). Tags are deleted along with their version.
+func (tree *MutableTree) TagVersion(version int64, tag string) error {
+	if tag == "" {
+		return errors.New("version tag must not be empty")
+	}
+	if !tree.VersionExists(version) {
+		return ErrVersionDoesNotExist
+	}
+	tagged, err := tree.ndb.getVersionByTag(tag)
+	if err != nil {
+		return err
+	}
+	if tagged != 0 {
+		return errors.Errorf("tag %q is already used by version %d", tag, tagged)
+	}
+	existing, err := tree.ndb.getVersionTag(version)
+	if err != nil {
+		return err
+	}
+	if existing != "" {
+		return errors.Errorf("version %d is already tagged %q", version, existing)
+	}
+
+	if err := tree.ndb.SaveVersionTag(tag, version); err != nil {
+		return err
+	}
+	return tree.ndb.Commit()
+}
+
+// GetVersionByTag returns the version with the given tag, or ErrTagNotFound if there is no such
+// tag.
+func (tree *MutableTree) GetVersionByTag(tag string) (int64, error) {
+	version, err := tree.ndb.getVersionByTag(tag)
+	if err != nil {
+		return 0, err
+	}
+	if version == 0 {
+		return 0, errors.Wrapf(ErrTagNotFound, "tag %q", tag)
+	}
+	return version, nil
+}
+
+// UntagVersion removes a version tag, or returns ErrTagNotFound if there is no such tag.
+func (tree *MutableTree) UntagVersion(tag string) error {
+	if _, err := tree.GetVersionByTag(tag); err != nil {
+		return err
+	}
+	if err := tree.ndb.DeleteVersionTag(tag); err != nil {
+		return err
+	}
+	return tree.ndb.Commit()
+}
+
+// CountOrphans returns the number of orphan records in the database, i.e. nodes which are no
+// longer part of the latest version but are kept for earlier versions.
+func (tree *MutableTree) CountOrphans() (int64, error) {
+	return tree.ndb.countOrphans()
+}
+
+// OrphanSizeBytes estimates the disk space held by orphans, as the total size of the keys and
+// values of the orphan records and of the orphaned nodes. It reads every orphaned node, so is
+// much slower than CountOrphans.
+func (tree *MutableTree) OrphanSizeBytes() (int64, error) {
+	return tree.ndb.orphanSizeBytes()
+}
+
 // SetInitialVersion sets the initial version of the tree, replacing Options.InitialVersion.
 // It is only used during the initial SaveVersion() call for a tree with no other versions,
 // and is otherwise ignored.
@@ -1002,6 +1999,12 @@ func (tree *MutableTree) SetInitialVersion(version uint64) {
 	tree.ndb.opts.InitialVersion = version
 }
 
+// Sync forces all data written by previous calls to SaveVersion() to be flushed to disk.
+// It is a no-op for in-memory databases.
+func (tree *MutableTree) Sync() error {
+	return tree.ndb.Sync()
+}
+
 // DeleteVersions deletes a series of versions from the MutableTree.
 // Deprecated: please use DeleteVersionsRange instead.
 func (tree *MutableTree) DeleteVersions(versions ...int64) error {
@@ -1074,6 +2077,101 @@ func (tree *MutableTree) DeleteVersion(version int64) error {
 	return nil
 }
 
+// RecoverFromCorruption repairs the leaves of the working tree whose stored hash does not match
+// their contents. repairFn is called with the key of each corrupted leaf, and returns either the
+// value to set for the key, or remove=true to remove the key from the tree. Once all leaves have
+// been repaired, the affected paths are rehashed and the tree is verified again. The repairs are
+// part of the working tree, and must be persisted with SaveVersion.
+func (tree *MutableTree) RecoverFromCorruption(repairFn func(key []byte) (value []byte, remove bool, err error)) error {
+	corrupted, err := tree.ImmutableTree.findCorruptedLeaves()
+	if err != nil {
+		return err
+	}
+
+	for _, node := range corrupted {
+		value, remove, err := repairFn(node.key)
+		if err != nil {
+			return errors.Wrapf(err, "repairing key %X", node.key)
+		}
+		if remove {
+			if _, _, err := tree.Remove(node.key); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := tree.Set(node.key, value); err != nil {
+			return err
+		}
+	}
+
+	corrupted, err = tree.ImmutableTree.findCorruptedLeaves()
+	if err != nil {
+		return err
+	}
+	if len(corrupted) > 0 {
+		return errors.Errorf("found %d corrupted leaves after recovery", len(corrupted))
+	}
+	return nil
+}
+
+// CommitToDB writes the current working tree, including unsaved changes, to db. The primary
+// database, the caches and the tree version are left untouched. The root is stored under the
+// version the working tree would be saved as, so db can be loaded as a standalone IAVL
+// database with LoadVersion().
+func (tree *MutableTree) CommitToDB(db dbm.DB) error {
+	version := tree.version + 1
+	if version == 1 && tree.ndb.opts.InitialVersion > 0 {
+		version = int64(tree.ndb.opts.InitialVersion)
+	}
+
+	// Make sure all hashes of the working tree have been computed.
+	rootHash, err := tree.WorkingHash()
+	if err != nil {
+		return err
+	}
+
+	batch := db.NewBatch()
+	defer batch.Close()
+
+	if tree.root == nil {
+		rootHash = []byte{}
+	} else if err := tree.writeSubtreeToBatch(batch, tree.root); err != nil {
+		return err
+	}
+	if err := batch.Set(tree.ndb.rootKey(version), rootHash); err != nil {
+		return err
+	}
+	return batch.WriteSync()
+}
+
+// writeSubtreeToBatch writes node and all of its descendants to batch.
+func (tree *MutableTree) writeSubtreeToBatch(batch dbm.Batch, node *Node) error {
+	var buf bytes.Buffer
+	buf.Grow(node.encodedSize())
+	if err := node.writeBytes(&buf); err != nil {
+		return err
+	}
+	if err := batch.Set(tree.ndb.nodeKey(node.hash), buf.Bytes()); err != nil {
+		return err
+	}
+	if node.isLeaf() {
+		return nil
+	}
+
+	leftNode, err := node.getLeftNode(tree.ImmutableTree)
+	if err != nil {
+		return err
+	}
+	if err := tree.writeSubtreeToBatch(batch, leftNode); err != nil {
+		return err
+	}
+	rightNode, err := node.getRightNode(tree.ImmutableTree)
+	if err != nil {
+		return err
+	}
+	return tree.writeSubtreeToBatch(batch, rightNode)
+}
+
 // Rotate right and return the new node and orphan.
 func (tree *MutableTree) rotateRight(node *Node) (*Node, *Node, error) {
 	version := tree.version + 1
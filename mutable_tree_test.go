@@ -2,11 +2,15 @@ package iavl
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"runtime"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 
@@ -449,6 +453,11 @@ func TestMutableTree_VersionExists(t *testing.T) {
 	require.True(t, tree.VersionExists(1))
 	require.True(t, tree.VersionExists(2))
 	require.False(t, tree.VersionExists(3))
+
+	_, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+	_, err = tree.GetImmutable(3)
+	require.ErrorIs(t, err, ErrVersionDoesNotExist)
 }
 
 func checkGetVersioned(t *testing.T, tree *MutableTree, version int64, key, value []byte) {
@@ -1516,3 +1525,1436 @@ func TestNoFastStorageUpgrade_Integration_SaveVersion_Load_Iterate_Success(t *te
 		})
 	})
 }
+
+func TestMutableTree_RecoverFromCorruption(t *testing.T) {
+	memDB := db.NewMemDB()
+	tree, err := NewMutableTree(memDB, 0, false)
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		_, err := tree.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)))
+		require.NoError(t, err)
+	}
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+
+	// Overwrite the stored bytes of two leaves with different values.
+	corrupt := func(key []byte) {
+		path, leaf, err := tree.root.PathToLeaf(tree.ImmutableTree, key)
+		require.NoError(t, err)
+		require.NotEmpty(t, path)
+
+		bad := *leaf
+		bad.value = []byte("garbage")
+		var buf bytes.Buffer
+		require.NoError(t, bad.writeBytes(&buf))
+		require.NoError(t, memDB.Set(tree.ndb.nodeKey(leaf.hash), buf.Bytes()))
+	}
+	corrupt([]byte("key3"))
+	corrupt([]byte("key7"))
+
+	tree, err = NewMutableTree(memDB, 0, false)
+	require.NoError(t, err)
+	_, err = tree.Load()
+	require.NoError(t, err)
+
+	repaired := map[string]bool{}
+	err = tree.RecoverFromCorruption(func(key []byte) ([]byte, bool, error) {
+		repaired[string(key)] = true
+		if bytes.Equal(key, []byte("key7")) {
+			return nil, true, nil
+		}
+		return []byte("repaired"), false, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"key3": true, "key7": true}, repaired)
+
+	value, err := tree.Get([]byte("key3"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("repaired"), value)
+	has, err := tree.Has([]byte("key7"))
+	require.NoError(t, err)
+	require.False(t, has)
+
+	corrupted, err := tree.findCorruptedLeaves()
+	require.NoError(t, err)
+	require.Empty(t, corrupted)
+
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+
+	// A failing repair function aborts the recovery.
+	corrupt([]byte("key5"))
+	tree, err = NewMutableTree(memDB, 0, false)
+	require.NoError(t, err)
+	_, err = tree.Load()
+	require.NoError(t, err)
+	err = tree.RecoverFromCorruption(func(key []byte) ([]byte, bool, error) {
+		return nil, false, errors.New("unrecoverable")
+	})
+	require.Error(t, err)
+}
+
+func TestMutableTree_CommitToDB(t *testing.T) {
+	tree := setupMutableTree(t, false)
+	for i := 0; i < 20; i++ {
+		_, err := tree.Set([]byte{byte(i)}, []byte{byte(i)})
+		require.NoError(t, err)
+	}
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+
+	_, err = tree.Set([]byte{0x05}, []byte("new"))
+	require.NoError(t, err)
+	_, _, err = tree.Remove([]byte{0x07})
+	require.NoError(t, err)
+	workingHash, err := tree.WorkingHash()
+	require.NoError(t, err)
+
+	backup := db.NewMemDB()
+	require.NoError(t, tree.CommitToDB(backup))
+
+	// The primary tree must not have moved.
+	require.EqualValues(t, 1, tree.Version())
+	latest, err := tree.ndb.getLatestVersion()
+	require.NoError(t, err)
+	require.EqualValues(t, 1, latest)
+
+	restored, err := NewMutableTree(backup, 0, false)
+	require.NoError(t, err)
+	version, err := restored.Load()
+	require.NoError(t, err)
+	require.EqualValues(t, 2, version)
+	hash, err := restored.Hash()
+	require.NoError(t, err)
+	require.Equal(t, workingHash, hash)
+
+	value, err := restored.Get([]byte{0x05})
+	require.NoError(t, err)
+	require.Equal(t, []byte("new"), value)
+	has, err := restored.Has([]byte{0x07})
+	require.NoError(t, err)
+	require.False(t, has)
+}
+
+func TestMutableTree_KeyExistsAt(t *testing.T) {
+	tree := setupMutableTree(t, false)
+	_, err := tree.Set([]byte("a"), []byte("1"))
+	require.NoError(t, err)
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	_, _, err = tree.Remove([]byte("a"))
+	require.NoError(t, err)
+	_, err = tree.Set([]byte("b"), []byte("2"))
+	require.NoError(t, err)
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+
+	exists, err := tree.KeyExistsAt([]byte("a"), 1)
+	require.NoError(t, err)
+	require.True(t, exists)
+	exists, err = tree.KeyExistsAt([]byte("b"), 1)
+	require.NoError(t, err)
+	require.False(t, exists)
+	exists, err = tree.KeyExistsAt([]byte("a"), 2)
+	require.NoError(t, err)
+	require.False(t, exists)
+	exists, err = tree.KeyExistsAt([]byte("b"), 2)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	_, err = tree.KeyExistsAt([]byte("a"), 3)
+	require.ErrorIs(t, err, ErrVersionDoesNotExist)
+}
+
+func TestMutableTree_AbsorbImmutable(t *testing.T) {
+	source := setupMutableTree(t, false)
+	for _, k := range []string{"a", "b", "c"} {
+		_, err := source.Set([]byte(k), []byte("src"+k))
+		require.NoError(t, err)
+	}
+	_, version, err := source.SaveVersion()
+	require.NoError(t, err)
+	snapshot, err := source.GetImmutable(version)
+	require.NoError(t, err)
+
+	tree := setupMutableTree(t, false)
+	_, err = tree.Set([]byte("b"), []byte("own"))
+	require.NoError(t, err)
+	_, err = tree.Set([]byte("d"), []byte("own"))
+	require.NoError(t, err)
+
+	err = tree.AbsorbImmutable(snapshot, false)
+	require.ErrorIs(t, err, ErrKeyConflict)
+	has, err := tree.Has([]byte("a"))
+	require.NoError(t, err)
+	require.False(t, has, "nothing must be absorbed on conflict")
+
+	require.NoError(t, tree.AbsorbImmutable(snapshot, true))
+	for k, v := range map[string]string{"a": "srca", "b": "srcb", "c": "srcc", "d": "own"} {
+		value, err := tree.Get([]byte(k))
+		require.NoError(t, err)
+		require.Equal(t, []byte(v), value)
+	}
+
+	// Unsaved changes on top of a saved version are taken into account.
+	tree = setupMutableTree(t, false)
+	_, err = tree.Set([]byte("a"), []byte("own"))
+	require.NoError(t, err)
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	_, _, err = tree.Remove([]byte("a"))
+	require.NoError(t, err)
+	_, err = tree.Set([]byte("c"), []byte("own"))
+	require.NoError(t, err)
+	hash, err := tree.WorkingHash()
+	require.NoError(t, err)
+
+	err = tree.AbsorbImmutable(snapshot, false)
+	require.ErrorIs(t, err, ErrKeyConflict)
+	newHash, err := tree.WorkingHash()
+	require.NoError(t, err)
+	require.Equal(t, hash, newHash)
+	has, err = tree.Has([]byte("b"))
+	require.NoError(t, err)
+	require.False(t, has, "absorbed keys must be rolled back on conflict")
+
+	_, _, err = tree.Remove([]byte("c"))
+	require.NoError(t, err)
+	require.NoError(t, tree.AbsorbImmutable(snapshot, false))
+	value, err := tree.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("srca"), value)
+}
+
+func TestMutableTree_AbsorbImmutable_Error(t *testing.T) {
+	memDB := db.NewMemDB()
+	source, err := NewMutableTree(memDB, 0, true)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err := source.Set([]byte{byte(i)}, []byte{byte(i)})
+		require.NoError(t, err)
+	}
+	_, version, err := source.SaveVersion()
+	require.NoError(t, err)
+
+	// Without a node cache, iterating the snapshot fails once the leaf of key 9 is missing.
+	hashes, err := source.HashesForRange([]byte{9}, nil)
+	require.NoError(t, err)
+	require.NoError(t, memDB.Delete(source.ndb.nodeKey(hashes[0])))
+	snapshot, err := source.GetImmutable(version)
+	require.NoError(t, err)
+
+	tree := setupMutableTree(t, false)
+	_, err = tree.Set([]byte("a"), []byte("own"))
+	require.NoError(t, err)
+	hash, err := tree.WorkingHash()
+	require.NoError(t, err)
+
+	require.Error(t, tree.AbsorbImmutable(snapshot, true))
+	newHash, err := tree.WorkingHash()
+	require.NoError(t, err)
+	require.Equal(t, hash, newHash)
+	has, err := tree.Has([]byte{0})
+	require.NoError(t, err)
+	require.False(t, has, "absorbed keys must be rolled back on error")
+}
+
+func TestMutableTree_Has(t *testing.T) {
+	for _, skipFast := range []bool{false, true} {
+		tree := setupMutableTree(t, skipFast)
+		for i := 0; i < 10; i++ {
+			_, err := tree.Set([]byte{byte(i)}, []byte{byte(i)})
+			require.NoError(t, err)
+		}
+		_, _, err := tree.SaveVersion()
+		require.NoError(t, err)
+
+		_, err = tree.Set([]byte{100}, []byte{})
+		require.NoError(t, err)
+		_, _, err = tree.Remove([]byte{5})
+		require.NoError(t, err)
+
+		for key, expected := range map[byte]bool{0: true, 5: false, 9: true, 10: false, 100: true} {
+			has, err := tree.Has([]byte{key})
+			require.NoError(t, err)
+			require.Equal(t, expected, has, "key %d, skipFast %v", key, skipFast)
+		}
+	}
+}
+
+func TestMutableTree_AtomicCompareAndSwapAll(t *testing.T) {
+	tree := setupMutableTree(t, false)
+	_, err := tree.Set([]byte("a"), []byte("1"))
+	require.NoError(t, err)
+	_, err = tree.Set([]byte("b"), []byte("2"))
+	require.NoError(t, err)
+	hash, err := tree.WorkingHash()
+	require.NoError(t, err)
+
+	newState := []KVPair{
+		{Key: []byte("a"), Value: []byte("10")},
+		{Key: []byte("b"), Value: nil},
+		{Key: []byte("c"), Value: []byte("30")},
+	}
+	for _, expected := range [][]KVPair{
+		{{Key: []byte("a"), Value: []byte("1")}, {Key: []byte("b"), Value: []byte("3")}},
+		{{Key: []byte("a"), Value: []byte("1")}, {Key: []byte("c"), Value: []byte("")}},
+		{{Key: []byte("b"), Value: nil}},
+	} {
+		swapped, err := tree.AtomicCompareAndSwapAll(expected, newState)
+		require.NoError(t, err)
+		require.False(t, swapped)
+		actual, err := tree.WorkingHash()
+		require.NoError(t, err)
+		require.Equal(t, hash, actual)
+	}
+
+	swapped, err := tree.AtomicCompareAndSwapAll([]KVPair{
+		{Key: []byte("a"), Value: []byte("1")},
+		{Key: []byte("b"), Value: []byte("2")},
+		{Key: []byte("c"), Value: nil},
+	}, newState)
+	require.NoError(t, err)
+	require.True(t, swapped)
+	for key, expected := range map[string][]byte{"a": []byte("10"), "b": nil, "c": []byte("30")} {
+		value, err := tree.Get([]byte(key))
+		require.NoError(t, err)
+		require.Equal(t, expected, value)
+	}
+}
+
+func TestMutableTree_AtomicCompareAndSwapAll_Error(t *testing.T) {
+	memDB := db.NewMemDB()
+	tree, err := NewMutableTree(memDB, 0, true)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err := tree.Set([]byte{byte(i)}, []byte{byte(i)})
+		require.NoError(t, err)
+	}
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+
+	// Without a node cache, setting key 9 fails once its leaf is missing from the database.
+	hashes, err := tree.HashesForRange([]byte{9}, nil)
+	require.NoError(t, err)
+	require.NoError(t, memDB.Delete(tree.ndb.nodeKey(hashes[0])))
+
+	hashBefore, err := tree.WorkingHash()
+	require.NoError(t, err)
+	swapped, err := tree.AtomicCompareAndSwapAll(
+		[]KVPair{{Key: []byte{0}, Value: []byte{0}}},
+		[]KVPair{{Key: []byte{0}, Value: []byte("new")}, {Key: []byte{1}}, {Key: []byte{9}, Value: []byte("new")}},
+	)
+	require.Error(t, err)
+	require.False(t, swapped)
+	hash, err := tree.WorkingHash()
+	require.NoError(t, err)
+	require.Equal(t, hashBefore, hash)
+	value, err := tree.Get([]byte{0})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0}, value)
+}
+
+func TestMutableTree_ReadSnapshotAt(t *testing.T) {
+	tree := setupMutableTree(t, false)
+	for i := 0; i < 3; i++ {
+		_, err := tree.Set([]byte("k"), []byte{byte(i)})
+		require.NoError(t, err)
+		_, _, err = tree.SaveVersion()
+		require.NoError(t, err)
+	}
+
+	err := tree.ReadSnapshotAt(2, func(t2 *ImmutableTree) error {
+		value, err := t2.Get([]byte("k"))
+		require.NoError(t, err)
+		require.Equal(t, []byte{1}, value)
+
+		// The version can't be deleted while it is being read.
+		require.Error(t, tree.DeleteVersion(2))
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, tree.DeleteVersion(2))
+
+	fnErr := errors.New("fn failed")
+	err = tree.ReadSnapshotAt(1, func(*ImmutableTree) error { return fnErr })
+	require.ErrorIs(t, err, fnErr)
+	require.NoError(t, tree.DeleteVersion(1))
+
+	err = tree.ReadSnapshotAt(2, func(*ImmutableTree) error { return nil })
+	require.ErrorIs(t, err, ErrVersionDoesNotExist)
+	require.Empty(t, tree.ndb.versionReaders)
+}
+
+func TestMutableTree_AtomicRename(t *testing.T) {
+	tree := setupMutableTree(t, false)
+	for i := 0; i < 10; i++ {
+		_, err := tree.Set([]byte{byte(i)}, []byte{byte(i)})
+		require.NoError(t, err)
+	}
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+
+	require.NoError(t, tree.AtomicRename([]byte{3}, []byte{30}))
+	value, err := tree.Get([]byte{30})
+	require.NoError(t, err)
+	require.Equal(t, []byte{3}, value)
+	has, err := tree.Has([]byte{3})
+	require.NoError(t, err)
+	require.False(t, has)
+
+	hashBefore, err := tree.WorkingHash()
+	require.NoError(t, err)
+	err = tree.AtomicRename([]byte{3}, []byte{31})
+	require.ErrorIs(t, err, ErrKeyNotFound)
+	err = tree.AtomicRename([]byte{4}, []byte{5})
+	require.ErrorIs(t, err, ErrKeyExists)
+	hash, err := tree.WorkingHash()
+	require.NoError(t, err)
+	require.Equal(t, hashBefore, hash)
+	value, err = tree.Get([]byte{4})
+	require.NoError(t, err)
+	require.Equal(t, []byte{4}, value)
+	value, err = tree.Get([]byte{5})
+	require.NoError(t, err)
+	require.Equal(t, []byte{5}, value)
+
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	require.EqualValues(t, 10, tree.Size())
+}
+
+func TestMutableTree_CommitBatch(t *testing.T) {
+	tree := setupMutableTree(t, false)
+	expected := setupMutableTree(t, false)
+	for _, tr := range []*MutableTree{tree, expected} {
+		for i := 0; i < 10; i++ {
+			_, err := tr.Set([]byte{byte(i)}, []byte{byte(i)})
+			require.NoError(t, err)
+		}
+		_, _, err := tr.SaveVersion()
+		require.NoError(t, err)
+		_, err = tr.Set([]byte("unsaved"), []byte{})
+		require.NoError(t, err)
+	}
+
+	ops := []KVOp{
+		{Key: []byte{3}, Delete: true},
+		{Key: []byte{4}, Value: []byte("four")},
+		{Key: []byte{20}, Value: []byte("twenty")},
+	}
+	_, _, err := expected.Remove([]byte{3})
+	require.NoError(t, err)
+	_, err = expected.Set([]byte{4}, []byte("four"))
+	require.NoError(t, err)
+	_, err = expected.Set([]byte{20}, []byte("twenty"))
+	require.NoError(t, err)
+	expectedHash, err := expected.WorkingHash()
+	require.NoError(t, err)
+
+	// A mismatch rolls back the batch, keeping earlier unsaved changes.
+	hashBefore, err := tree.WorkingHash()
+	require.NoError(t, err)
+	actual, err := tree.CommitBatch(ops, []byte("wrong"))
+	require.ErrorIs(t, err, ErrHashMismatch)
+	require.Equal(t, expectedHash, actual)
+	require.EqualValues(t, 1, tree.Version())
+	hash, err := tree.WorkingHash()
+	require.NoError(t, err)
+	require.Equal(t, hashBefore, hash)
+	value, err := tree.Get([]byte{4})
+	require.NoError(t, err)
+	require.Equal(t, []byte{4}, value)
+	has, err := tree.Has([]byte("unsaved"))
+	require.NoError(t, err)
+	require.True(t, has)
+
+	actual, err = tree.CommitBatch(ops, expectedHash)
+	require.NoError(t, err)
+	require.Equal(t, expectedHash, actual)
+	require.EqualValues(t, 2, tree.Version())
+	value, err = tree.Get([]byte{20})
+	require.NoError(t, err)
+	require.Equal(t, []byte("twenty"), value)
+}
+
+func TestMutableTree_IterateByVersion(t *testing.T) {
+	tree := setupMutableTree(t, false)
+	for i := 0; i < 3; i++ {
+		_, err := tree.Set([]byte{byte(i)}, []byte{byte(i)})
+		require.NoError(t, err)
+		_, _, err = tree.SaveVersion()
+		require.NoError(t, err)
+	}
+
+	keys := [][]byte{}
+	stopped, err := tree.IterateByVersion(2, func(key, value []byte) bool {
+		keys = append(keys, key)
+		return false
+	})
+	require.NoError(t, err)
+	require.False(t, stopped)
+	require.Equal(t, [][]byte{{0}, {1}}, keys)
+
+	stopped, err = tree.IterateByVersion(3, func(key, value []byte) bool {
+		return true
+	})
+	require.NoError(t, err)
+	require.True(t, stopped)
+
+	_, err = tree.IterateByVersion(4, func(key, value []byte) bool { return false })
+	require.ErrorIs(t, err, ErrVersionDoesNotExist)
+	require.Empty(t, tree.ndb.versionReaders)
+}
+
+func TestMutableTree_SetFromReader(t *testing.T) {
+	tree := setupMutableTree(t, false)
+
+	updated, err := tree.SetFromReader([]byte("a"), strings.NewReader("0123456789"), 10)
+	require.NoError(t, err)
+	require.False(t, updated)
+	value, err := tree.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("0123456789"), value)
+
+	updated, err = tree.SetFromReader([]byte("a"), strings.NewReader(""), 10)
+	require.NoError(t, err)
+	require.True(t, updated)
+	value, err = tree.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, []byte{}, value)
+
+	_, err = tree.SetFromReader([]byte("b"), strings.NewReader("0123456789"), 9)
+	require.ErrorIs(t, err, ErrValueTooLarge)
+	has, err := tree.Has([]byte("b"))
+	require.NoError(t, err)
+	require.False(t, has)
+}
+
+func TestMutableTree_SaveVersionAs(t *testing.T) {
+	tree := setupMutableTree(t, false)
+	for i := 0; i < 2; i++ {
+		_, err := tree.Set([]byte{byte(i)}, []byte{byte(i)})
+		require.NoError(t, err)
+		_, _, err = tree.SaveVersion()
+		require.NoError(t, err)
+	}
+
+	_, err := tree.SaveVersionAs(2)
+	require.ErrorIs(t, err, ErrVersionConflict)
+
+	// Skipping versions.
+	_, err = tree.Set([]byte{5}, []byte{5})
+	require.NoError(t, err)
+	hash, err := tree.SaveVersionAs(5)
+	require.NoError(t, err)
+	require.EqualValues(t, 5, tree.Version())
+	require.False(t, tree.VersionExists(3))
+	require.Equal(t, []int{1, 2, 5}, tree.AvailableVersions())
+	savedHash, err := tree.Hash()
+	require.NoError(t, err)
+	require.Equal(t, hash, savedHash)
+	value, err := tree.Get([]byte{5})
+	require.NoError(t, err)
+	require.Equal(t, []byte{5}, value)
+
+	_, err = tree.Set([]byte{6}, []byte{6})
+	require.NoError(t, err)
+	_, version, err := tree.SaveVersion()
+	require.NoError(t, err)
+	require.EqualValues(t, 6, version)
+
+	// A version before the latest one is saved from the working tree, which is left unsaved.
+	_, err = tree.Set([]byte{0}, []byte("historical"))
+	require.NoError(t, err)
+	workingHash, err := tree.WorkingHash()
+	require.NoError(t, err)
+	hash, err = tree.SaveVersionAs(3)
+	require.NoError(t, err)
+	_, err = tree.SaveVersionAs(3)
+	require.ErrorIs(t, err, ErrVersionConflict)
+	require.EqualValues(t, 6, tree.Version())
+	require.Equal(t, []int{1, 2, 3, 5, 6}, tree.AvailableVersions())
+	workingHash2, err := tree.WorkingHash()
+	require.NoError(t, err)
+	require.Equal(t, workingHash, workingHash2)
+	tree.Rollback()
+
+	// The versions are persisted.
+	reloaded, err := NewMutableTree(tree.ndb.db, 0, false)
+	require.NoError(t, err)
+	version, err = reloaded.Load()
+	require.NoError(t, err)
+	require.EqualValues(t, 6, version)
+	require.Equal(t, []int{1, 2, 3, 5, 6}, reloaded.AvailableVersions())
+	for key, expected := range map[byte][]byte{0: {0}, 1: {1}, 5: {5}, 6: {6}} {
+		value, err := reloaded.Get([]byte{key})
+		require.NoError(t, err)
+		require.Equal(t, expected, value)
+	}
+	for i := 7; i <= 8; i++ {
+		_, err = reloaded.Set([]byte{byte(i)}, []byte{byte(i)})
+		require.NoError(t, err)
+		_, _, err = reloaded.SaveVersion()
+		require.NoError(t, err)
+	}
+	require.EqualValues(t, 8, reloaded.Version())
+
+	// Pruning the other versions keeps the nodes of the historical version, and pruning it
+	// leaves only the nodes of the latest version.
+	for _, version := range []int64{7, 6, 5, 2, 1} {
+		require.NoError(t, reloaded.DeleteVersion(version))
+	}
+	historical, err := reloaded.GetImmutable(3)
+	require.NoError(t, err)
+	historicalHash, err := historical.Hash()
+	require.NoError(t, err)
+	require.Equal(t, hash, historicalHash)
+	for key, expected := range map[byte][]byte{0: []byte("historical"), 1: {1}, 5: {5}, 6: {6}} {
+		value, err := historical.Get([]byte{key})
+		require.NoError(t, err)
+		require.Equal(t, expected, value)
+	}
+	require.NoError(t, reloaded.DeleteVersion(3))
+	latest, err := reloaded.GetImmutable(8)
+	require.NoError(t, err)
+	for key, expected := range map[byte][]byte{0: {0}, 1: {1}, 5: {5}, 6: {6}, 7: {7}, 8: {8}} {
+		value, err := latest.Get([]byte{key})
+		require.NoError(t, err)
+		require.Equal(t, expected, value)
+	}
+	nodes, err := reloaded.ndb.nodes()
+	require.NoError(t, err)
+	require.Len(t, nodes, 2*int(latest.Size())-1)
+}
+
+func TestMutableTree_SaveVersionAs_WriteFailure(t *testing.T) {
+	tree, err := NewMutableTree(db.NewMemDB(), 0, true)
+	require.NoError(t, err)
+	_, err = tree.Set([]byte{1}, []byte{1})
+	require.NoError(t, err)
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	_, err = tree.Set([]byte{2}, []byte{2})
+	require.NoError(t, err)
+	workingHash, err := tree.WorkingHash()
+	require.NoError(t, err)
+
+	// A failed save leaves the working tree as it was.
+	batch := tree.ndb.batch
+	ctrl := gomock.NewController(t)
+	batchMock := mock.NewMockBatch(ctrl)
+	batchMock.EXPECT().Set(gomock.Any(), gomock.Any()).Return(errors.New("failed")).Times(1)
+	tree.ndb.batch = batchMock
+	_, err = tree.SaveVersionAs(5)
+	require.Error(t, err)
+	tree.ndb.batch = batch
+	hash, err := tree.WorkingHash()
+	require.NoError(t, err)
+	require.Equal(t, workingHash, hash)
+
+	hash, version, err := tree.SaveVersion()
+	require.NoError(t, err)
+	require.EqualValues(t, 2, version)
+	require.Equal(t, workingHash, hash)
+	itree, err := tree.GetImmutable(2)
+	require.NoError(t, err)
+	savedHash, err := itree.Hash()
+	require.NoError(t, err)
+	require.Equal(t, hash, savedHash)
+}
+
+func TestMutableTree_HashWithoutKeys(t *testing.T) {
+	// expected receives the same changes, and then actually removes the keys.
+	tree := setupMutableTree(t, false)
+	expected := setupMutableTree(t, false)
+	for _, tr := range []*MutableTree{tree, expected} {
+		for i := 0; i < 50; i++ {
+			_, err := tr.Set([]byte{byte(i)}, []byte{byte(i)})
+			require.NoError(t, err)
+		}
+		_, _, err := tr.SaveVersion()
+		require.NoError(t, err)
+		_, err = tr.Set([]byte{0xff}, []byte("ephemeral"))
+		require.NoError(t, err)
+	}
+
+	hashBefore, err := tree.WorkingHash()
+	require.NoError(t, err)
+
+	excluded := [][]byte{{0xff}, {0xfe}} // 0xfe does not exist
+	for i := 0; i < 50; i += 7 {
+		excluded = append(excluded, []byte{byte(i)})
+	}
+	hash, err := tree.HashWithoutKeys(excluded)
+	require.NoError(t, err)
+	for _, key := range excluded {
+		_, _, err = expected.Remove(key)
+		require.NoError(t, err)
+	}
+	expectedHash, err := expected.WorkingHash()
+	require.NoError(t, err)
+	require.Equal(t, expectedHash, hash)
+	require.NotEqual(t, hashBefore, hash)
+
+	// The tree itself must be unchanged.
+	hashAfter, err := tree.WorkingHash()
+	require.NoError(t, err)
+	require.Equal(t, hashBefore, hashAfter)
+	value, err := tree.Get([]byte{0})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0}, value)
+	savedHash, version, err := tree.SaveVersion()
+	require.NoError(t, err)
+	require.EqualValues(t, 2, version)
+	require.Equal(t, hashBefore, savedHash)
+	has, err := tree.Has([]byte{0xff})
+	require.NoError(t, err)
+	require.True(t, has)
+}
+
+func TestMutableTree_ExpectedRootAfterDelete(t *testing.T) {
+	tree := setupMutableTree(t, false)
+	expected := setupMutableTree(t, false)
+	for _, tr := range []*MutableTree{tree, expected} {
+		for i := 0; i < 20; i++ {
+			_, err := tr.Set([]byte{byte(i)}, []byte{byte(i)})
+			require.NoError(t, err)
+		}
+		_, _, err := tr.SaveVersion()
+		require.NoError(t, err)
+	}
+
+	hashBefore, err := tree.WorkingHash()
+	require.NoError(t, err)
+	hash, err := tree.ExpectedRootAfterDelete([]byte{7})
+	require.NoError(t, err)
+
+	_, removed, err := expected.Remove([]byte{7})
+	require.NoError(t, err)
+	require.True(t, removed)
+	expectedHash, err := expected.WorkingHash()
+	require.NoError(t, err)
+	require.Equal(t, expectedHash, hash)
+
+	hashAfter, err := tree.WorkingHash()
+	require.NoError(t, err)
+	require.Equal(t, hashBefore, hashAfter)
+	has, err := tree.Has([]byte{7})
+	require.NoError(t, err)
+	require.True(t, has)
+
+	_, err = tree.ExpectedRootAfterDelete([]byte{0xff})
+	require.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestMutableTree_RootHashAfterApply(t *testing.T) {
+	tree := setupMutableTree(t, false)
+	expected := setupMutableTree(t, false)
+	for _, tr := range []*MutableTree{tree, expected} {
+		for i := 0; i < 20; i++ {
+			_, err := tr.Set([]byte{byte(i)}, []byte{byte(i)})
+			require.NoError(t, err)
+		}
+		_, _, err := tr.SaveVersion()
+		require.NoError(t, err)
+		_, err = tr.Set([]byte("unsaved"), []byte{})
+		require.NoError(t, err)
+	}
+
+	ops := []KVOp{
+		{Key: []byte{3}, Delete: true},
+		{Key: []byte{4}, Value: []byte("four")},
+		{Key: []byte{20}, Value: []byte("twenty")},
+		{Key: []byte{20}, Delete: true},
+		{Key: []byte{30}, Value: []byte("thirty")},
+	}
+	hashBefore, err := tree.WorkingHash()
+	require.NoError(t, err)
+	hash, err := tree.RootHashAfterApply(ops)
+	require.NoError(t, err)
+
+	for _, op := range ops {
+		if op.Delete {
+			_, _, err = expected.Remove(op.Key)
+		} else {
+			_, err = expected.Set(op.Key, op.Value)
+		}
+		require.NoError(t, err)
+	}
+	expectedHash, err := expected.WorkingHash()
+	require.NoError(t, err)
+	require.Equal(t, expectedHash, hash)
+
+	hashAfter, err := tree.WorkingHash()
+	require.NoError(t, err)
+	require.Equal(t, hashBefore, hashAfter)
+	value, err := tree.Get([]byte{4})
+	require.NoError(t, err)
+	require.Equal(t, []byte{4}, value)
+	has, err := tree.Has([]byte{30})
+	require.NoError(t, err)
+	require.False(t, has)
+
+	// The tree still saves to the hash it had before.
+	saved, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+	require.Equal(t, hashBefore, saved)
+
+	_, err = tree.RootHashAfterApply([]KVOp{{Key: []byte{1}}})
+	require.Error(t, err)
+}
+
+func TestMutableTree_GetOrDefault(t *testing.T) {
+	tree := setupMutableTree(t, false)
+	_, err := tree.Set([]byte("a"), []byte("1"))
+	require.NoError(t, err)
+	_, err = tree.Set([]byte("b"), []byte{})
+	require.NoError(t, err)
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	_, _, err = tree.Remove([]byte("a"))
+	require.NoError(t, err)
+	_, err = tree.Set([]byte("c"), []byte("3"))
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		key, expected []byte
+	}{
+		{[]byte("a"), []byte("default")}, // removed but not saved
+		{[]byte("b"), []byte{}},
+		{[]byte("c"), []byte("3")},
+		{[]byte("d"), []byte("default")},
+	} {
+		value, err := tree.GetOrDefault(tc.key, []byte("default"))
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, value, "key %s", tc.key)
+	}
+
+	immutableTree, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+	value, err := immutableTree.GetOrDefault([]byte("a"), []byte("default"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("1"), value)
+	value, err = immutableTree.GetOrDefault([]byte("c"), nil)
+	require.NoError(t, err)
+	require.Nil(t, value)
+}
+
+func TestMutableTree_CacheWarmup(t *testing.T) {
+	memDB := db.NewMemDB()
+	tree, err := NewMutableTree(memDB, 0, true)
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		_, err := tree.Set([]byte{byte(i)}, []byte{byte(i)})
+		require.NoError(t, err)
+	}
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+
+	// Record the nodes read by a few lookups.
+	recorded, err := NewMutableTree(memDB, 100, true)
+	require.NoError(t, err)
+	_, err = recorded.Load()
+	require.NoError(t, err)
+	var log bytes.Buffer
+	stop := recorded.RecordCacheAccesses(&log)
+	for _, key := range []byte{3, 20, 41} {
+		_, err := recorded.Get([]byte{key})
+		require.NoError(t, err)
+	}
+	stop()
+	_, err = recorded.Get([]byte{10})
+	require.NoError(t, err)
+	hashes := strings.Fields(log.String())
+	require.NotEmpty(t, hashes)
+
+	warm, err := NewMutableTree(memDB, 100, true)
+	require.NoError(t, err)
+	_, err = warm.Load()
+	require.NoError(t, err)
+	missing := fmt.Sprintf("%x", sha256.Sum256([]byte("missing")))
+	warm.ResetPersistenceStats()
+	require.NoError(t, warm.CacheWarmup(strings.NewReader(log.String()+"\n"+missing+"\n")))
+	unique := map[string]bool{}
+	for _, hash := range hashes {
+		bz, err := hex.DecodeString(hash)
+		require.NoError(t, err)
+		require.True(t, warm.ndb.nodeCache.Has(bz))
+		unique[hash] = true
+	}
+	// The root and the recorded nodes, which were read more than once if on several paths.
+	require.Equal(t, len(unique)+1, warm.ndb.nodeCache.Len())
+	// Each node, and the missing one, is read from the database once.
+	require.EqualValues(t, len(unique)+1, warm.PersistenceStats().DBReads)
+
+	err = warm.CacheWarmup(strings.NewReader("not hex\n"))
+	require.Error(t, err)
+}
+
+func TestMutableTree_RecordCacheAccesses_ConcurrentReads(t *testing.T) {
+	tree, err := NewMutableTree(db.NewMemDB(), 0, true)
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		_, err := tree.Set([]byte{byte(i)}, []byte{byte(i)})
+		require.NoError(t, err)
+	}
+	_, version, err := tree.SaveVersion()
+	require.NoError(t, err)
+	itree, err := tree.GetImmutable(version)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				_, err := itree.Get([]byte{byte(j)})
+				require.NoError(t, err)
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		stop := tree.RecordCacheAccesses(io.Discard)
+		stop()
+	}
+	wg.Wait()
+}
+
+func TestMutableTree_WatchRoot(t *testing.T) {
+	tree := setupMutableTree(t, false)
+	ch := tree.WatchRoot()
+
+	// Versions saved while the receiver is not ready are dropped.
+	received := make(chan []byte, 1)
+	go func() { received <- <-ch }()
+	hashes := [][]byte{}
+	var hash []byte
+	for hash == nil {
+		_, err := tree.Set([]byte{byte(len(hashes))}, []byte{1})
+		require.NoError(t, err)
+		saved, _, err := tree.SaveVersion()
+		require.NoError(t, err)
+		hashes = append(hashes, saved)
+		select {
+		case hash = <-received:
+		default:
+		}
+	}
+	require.Contains(t, hashes, hash)
+	require.EqualValues(t, len(hashes)-1, tree.DroppedRootEvents())
+
+	tree.UnwatchRoot(ch)
+	_, ok := <-ch
+	require.False(t, ok)
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+	require.EqualValues(t, len(hashes)-1, tree.DroppedRootEvents())
+}
+
+func TestMutableTree_SetMaxVersionCount(t *testing.T) {
+	tree := setupMutableTree(t, false)
+	tree.SetMaxVersionCount(3)
+	for i := 0; i < 5; i++ {
+		_, err := tree.Set([]byte{byte(i)}, []byte{byte(i)})
+		require.NoError(t, err)
+		_, _, err = tree.SaveVersion()
+		require.NoError(t, err)
+	}
+	require.Equal(t, []int{3, 4, 5}, tree.AvailableVersions())
+	require.False(t, tree.VersionExists(2))
+
+	// Active readers make the deletion fail, which is reported by SaveVersion.
+	tree.ndb.incrVersionReaders(3)
+	_, version, err := tree.SaveVersion()
+	require.ErrorIs(t, err, ErrPruneFailed)
+	require.EqualValues(t, 6, version)
+	require.True(t, tree.VersionExists(6))
+	tree.ndb.decrVersionReaders(3)
+
+	tree.SetMaxVersionCount(0)
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	require.Equal(t, []int{3, 4, 5, 6, 7}, tree.AvailableVersions())
+}
+
+func TestMutableTree_SetMaxVersionCount_LazyLoad(t *testing.T) {
+	memDB := db.NewMemDB()
+	tree, err := NewMutableTree(memDB, 0, false)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		_, err := tree.Set([]byte{byte(i)}, []byte{byte(i)})
+		require.NoError(t, err)
+		_, _, err = tree.SaveVersion()
+		require.NoError(t, err)
+	}
+
+	// A lazily loaded tree only knows its latest version, but older ones are pruned as well.
+	reloaded, err := NewMutableTree(memDB, 0, false)
+	require.NoError(t, err)
+	_, err = reloaded.LazyLoadVersion(5)
+	require.NoError(t, err)
+	reloaded.SetMaxVersionCount(2)
+	_, _, err = reloaded.SaveVersion()
+	require.NoError(t, err)
+
+	versions, err := reloaded.ndb.getVersions()
+	require.NoError(t, err)
+	require.Equal(t, []int64{5, 6}, versions)
+}
+
+func TestMutableTree_IterateVersionChanges(t *testing.T) {
+	tree := setupMutableTree(t, false)
+	for i := 0; i < 100; i++ {
+		_, err := tree.Set([]byte{byte(i)}, []byte{byte(i)})
+		require.NoError(t, err)
+	}
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+
+	_, err = tree.Set([]byte{10}, []byte("changed"))
+	require.NoError(t, err)
+	_, err = tree.Set([]byte{20}, []byte{20}) // same value
+	require.NoError(t, err)
+	_, _, err = tree.Remove([]byte{30})
+	require.NoError(t, err)
+	_, err = tree.Set([]byte{200}, []byte("new"))
+	require.NoError(t, err)
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+
+	type change struct{ key, v1val, v2val []byte }
+	expected := []change{
+		{[]byte{10}, []byte{10}, []byte("changed")},
+		{[]byte{30}, []byte{30}, nil},
+		{[]byte{200}, nil, []byte("new")},
+	}
+	changes := []change{}
+	stopped, err := tree.IterateVersionChanges(1, 2, func(key, v1val, v2val []byte) bool {
+		changes = append(changes, change{key, v1val, v2val})
+		return false
+	})
+	require.NoError(t, err)
+	require.False(t, stopped)
+	require.Equal(t, expected, changes)
+
+	// Swapping the versions swaps the values.
+	changes = []change{}
+	_, err = tree.IterateVersionChanges(2, 1, func(key, v1val, v2val []byte) bool {
+		changes = append(changes, change{key, v2val, v1val})
+		return false
+	})
+	require.NoError(t, err)
+	require.Equal(t, expected, changes)
+
+	stopped, err = tree.IterateVersionChanges(1, 2, func(key, v1val, v2val []byte) bool {
+		return true
+	})
+	require.NoError(t, err)
+	require.True(t, stopped)
+
+	_, err = tree.IterateVersionChanges(1, 3, func(key, v1val, v2val []byte) bool { return false })
+	require.ErrorIs(t, err, ErrVersionDoesNotExist)
+}
+
+func TestMutableTree_SetEvictionListener(t *testing.T) {
+	tree, err := NewMutableTree(db.NewMemDB(), 10, false)
+	require.NoError(t, err)
+
+	evicted := map[string]bool{}
+	tree.SetEvictionListener(func(key []byte, node *Node) {
+		require.Equal(t, node.hash, key)
+		require.False(t, tree.ndb.nodeCache.Has(key))
+		evicted[string(key)] = true
+	})
+	for i := 0; i < 20; i++ {
+		_, err := tree.Set([]byte{byte(i)}, []byte{byte(i)})
+		require.NoError(t, err)
+	}
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+
+	// 39 nodes were saved to a cache of 10.
+	require.Len(t, evicted, 29)
+
+	tree.SetEvictionListener(nil)
+	for i := 20; i < 40; i++ {
+		_, err := tree.Set([]byte{byte(i)}, []byte{byte(i)})
+		require.NoError(t, err)
+	}
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	require.Len(t, evicted, 29)
+}
+
+func TestMutableTree_SetWithCallback(t *testing.T) {
+	tree, err := NewMutableTree(db.NewMemDB(), 10, false)
+	require.NoError(t, err)
+
+	evicted := map[string][]byte{}
+	onEvict := func(key, value []byte) {
+		require.NotContains(t, evicted, string(key))
+		evicted[string(key)] = value
+	}
+	require.NoError(t, tree.SetWithCallback([]byte{0}, []byte{100}, onEvict))
+	require.NoError(t, tree.SetWithCallback([]byte{1}, []byte{101}, onEvict))
+	require.NoError(t, tree.SetWithCallback([]byte{2}, []byte{102}, onEvict))
+	_, err = tree.Set([]byte{1}, []byte{1}) // drops the callback
+	require.NoError(t, err)
+	_, _, err = tree.Remove([]byte{2})
+	require.NoError(t, err)
+	for i := 3; i < 20; i++ {
+		_, err := tree.Set([]byte{byte(i)}, []byte{byte(i)})
+		require.NoError(t, err)
+	}
+	require.Empty(t, evicted)
+
+	// The leaf of key 0 is saved first, and evicted by the nodes saved after it.
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	require.Equal(t, map[string][]byte{string([]byte{0}): {100}}, evicted)
+
+	// Reloading and evicting the node again doesn't call the callback.
+	_, _, err = tree.GetWithIndex([]byte{0})
+	require.NoError(t, err)
+	for i := 20; i < 40; i++ {
+		_, err := tree.Set([]byte{byte(i)}, []byte{byte(i)})
+		require.NoError(t, err)
+	}
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	require.Len(t, evicted, 1)
+	require.Empty(t, tree.ndb.evictionCallbacks)
+}
+
+func TestMutableTree_SetWithCallback_SaveFailure(t *testing.T) {
+	tree, err := NewMutableTree(db.NewMemDB(), 10, true)
+	require.NoError(t, err)
+
+	var evicted [][]byte
+	onEvict := func(key, value []byte) { evicted = append(evicted, key) }
+	require.NoError(t, tree.SetWithCallback([]byte{0}, []byte{0}, onEvict))
+	_, err = tree.Set([]byte{1}, []byte{1})
+	require.NoError(t, err)
+
+	// No callback is registered if the nodes can't be saved.
+	batch := tree.ndb.batch
+	ctrl := gomock.NewController(t)
+	batchMock := mock.NewMockBatch(ctrl)
+	batchMock.EXPECT().Set(gomock.Any(), gomock.Any()).Return(errors.New("failed")).Times(1)
+	tree.ndb.batch = batchMock
+	_, _, err = tree.SaveVersion()
+	require.Error(t, err)
+	require.Empty(t, tree.ndb.evictionCallbacks)
+
+	tree.ndb.batch = batch
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	require.Len(t, tree.ndb.evictionCallbacks, 1)
+	require.Empty(t, evicted)
+}
+
+func TestMutableTree_SetWithCallback_NoCache(t *testing.T) {
+	tree, err := NewMutableTree(db.NewMemDB(), 0, true)
+	require.NoError(t, err)
+
+	var evicted [][]byte
+	require.NoError(t, tree.SetWithCallback([]byte{0}, []byte{0}, func(key, value []byte) {
+		evicted = append(evicted, key)
+	}))
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{{0}}, evicted)
+	require.Empty(t, tree.ndb.evictionCallbacks)
+}
+
+func TestMutableTree_MassDelete(t *testing.T) {
+	tree := setupMutableTree(t, false)
+	for i := 0; i < 100; i++ {
+		_, err := tree.Set([]byte{byte(i)}, []byte{byte(i % 4)})
+		require.NoError(t, err)
+	}
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+	_, err = tree.Set([]byte{0xf0}, []byte{0x00})
+	require.NoError(t, err)
+
+	deleted, err := tree.MassDelete(func(key, value []byte) bool {
+		return value[0] == 0
+	})
+	require.NoError(t, err)
+	require.Equal(t, 26, deleted)
+	require.EqualValues(t, 75, tree.Size())
+
+	_, err = tree.Iterate(func(key, value []byte) bool {
+		require.NotZero(t, value[0])
+		return false
+	})
+	require.NoError(t, err)
+
+	_, version, err := tree.SaveVersion()
+	require.NoError(t, err)
+	itree, err := tree.GetImmutable(version)
+	require.NoError(t, err)
+	require.EqualValues(t, 75, itree.Size())
+	has, err := itree.Has([]byte{4})
+	require.NoError(t, err)
+	require.False(t, has)
+
+	deleted, err = tree.MassDelete(func(key, value []byte) bool { return false })
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+}
+
+func TestMutableTree_VersionComment(t *testing.T) {
+	tree := setupMutableTree(t, false)
+	for i := 0; i < 4; i++ {
+		_, err := tree.Set([]byte{byte(i)}, []byte{byte(i)})
+		require.NoError(t, err)
+		_, _, err = tree.SaveVersion()
+		require.NoError(t, err)
+	}
+
+	comment, err := tree.GetVersionComment(1)
+	require.NoError(t, err)
+	require.Empty(t, comment)
+
+	require.NoError(t, tree.SetVersionComment(1, "block 100"))
+	require.NoError(t, tree.SetVersionComment(2, "epoch ✓"))
+	require.NoError(t, tree.SetVersionComment(3, "first"))
+	require.NoError(t, tree.SetVersionComment(3, "second"))
+
+	comment, err = tree.GetVersionComment(2)
+	require.NoError(t, err)
+	require.Equal(t, "epoch ✓", comment)
+	comment, err = tree.GetVersionComment(3)
+	require.NoError(t, err)
+	require.Equal(t, "second", comment)
+
+	require.ErrorIs(t, tree.SetVersionComment(5, "missing"), ErrVersionDoesNotExist)
+	_, err = tree.GetVersionComment(5)
+	require.ErrorIs(t, err, ErrVersionDoesNotExist)
+	require.Error(t, tree.SetVersionComment(1, strings.Repeat("a", 1025)))
+	require.NoError(t, tree.SetVersionComment(1, strings.Repeat("a", 1024)))
+	require.Error(t, tree.SetVersionComment(1, "\xff"))
+
+	// Comments are deleted along with their version.
+	require.NoError(t, tree.DeleteVersion(1))
+	require.NoError(t, tree.DeleteVersionsRange(2, 3))
+	for _, version := range []int64{1, 2} {
+		comment, err = tree.ndb.getVersionComment(version)
+		require.NoError(t, err)
+		require.Empty(t, comment)
+	}
+	comment, err = tree.GetVersionComment(3)
+	require.NoError(t, err)
+	require.Equal(t, "second", comment)
+
+	// And persisted.
+	reloaded, err := NewMutableTree(tree.ndb.db, 0, false)
+	require.NoError(t, err)
+	_, err = reloaded.Load()
+	require.NoError(t, err)
+	comment, err = reloaded.GetVersionComment(3)
+	require.NoError(t, err)
+	require.Equal(t, "second", comment)
+}
+
+func TestMutableTree_VersionTags(t *testing.T) {
+	tree := setupMutableTree(t, false)
+	for i := 0; i < 4; i++ {
+		_, err := tree.Set([]byte{byte(i)}, []byte{byte(i)})
+		require.NoError(t, err)
+		_, _, err = tree.SaveVersion()
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, tree.TagVersion(1, "genesis"))
+	require.NoError(t, tree.TagVersion(2, "v2"))
+	require.NoError(t, tree.TagVersion(3, "mainnet-v1.0"))
+
+	version, err := tree.GetVersionByTag("mainnet-v1.0")
+	require.NoError(t, err)
+	require.EqualValues(t, 3, version)
+	_, err = tree.GetVersionByTag("missing")
+	require.ErrorIs(t, err, ErrTagNotFound)
+
+	require.Error(t, tree.TagVersion(4, "genesis"), "tags must be unique")
+	require.Error(t, tree.TagVersion(3, "other"), "versions have at most one tag")
+	require.ErrorIs(t, tree.TagVersion(5, "future"), ErrVersionDoesNotExist)
+	require.Error(t, tree.TagVersion(4, ""))
+
+	// Re-tagging requires untagging first.
+	require.NoError(t, tree.UntagVersion("mainnet-v1.0"))
+	require.ErrorIs(t, tree.UntagVersion("mainnet-v1.0"), ErrTagNotFound)
+	require.NoError(t, tree.TagVersion(3, "other"))
+	require.NoError(t, tree.TagVersion(4, "mainnet-v1.0"))
+
+	// Tags are deleted along with their version.
+	require.NoError(t, tree.DeleteVersion(1))
+	require.NoError(t, tree.DeleteVersionsRange(2, 3))
+	for _, tag := range []string{"genesis", "v2"} {
+		_, err = tree.GetVersionByTag(tag)
+		require.ErrorIs(t, err, ErrTagNotFound)
+	}
+	for _, version := range []int64{1, 2} {
+		tag, err := tree.ndb.getVersionTag(version)
+		require.NoError(t, err)
+		require.Empty(t, tag)
+	}
+	tag, err := tree.ndb.getVersionTag(3)
+	require.NoError(t, err)
+	require.Equal(t, "other", tag)
+
+	// And persisted.
+	reloaded, err := NewMutableTree(tree.ndb.db, 0, false)
+	require.NoError(t, err)
+	_, err = reloaded.Load()
+	require.NoError(t, err)
+	version, err = reloaded.GetVersionByTag("other")
+	require.NoError(t, err)
+	require.EqualValues(t, 3, version)
+}
+
+func TestMutableTree_TruncateKeysWithPrefix(t *testing.T) {
+	tree := setupMutableTree(t, false)
+	keys := []string{"a", "ab", "abc", "ac", "b", "\xfe\xff", "\xff", "\xff\x00", "\xff\xff", "\xff\xff\x01"}
+	for _, key := range keys {
+		_, err := tree.Set([]byte(key), []byte(key))
+		require.NoError(t, err)
+	}
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+
+	remaining := func() []string {
+		result := []string{}
+		_, err := tree.Iterate(func(key, value []byte) bool {
+			result = append(result, string(key))
+			return false
+		})
+		require.NoError(t, err)
+		return result
+	}
+
+	deleted, err := tree.TruncateKeysWithPrefix([]byte("ab"))
+	require.NoError(t, err)
+	require.Equal(t, 2, deleted)
+	require.Equal(t, []string{"a", "ac", "b", "\xfe\xff", "\xff", "\xff\x00", "\xff\xff", "\xff\xff\x01"}, remaining())
+
+	deleted, err = tree.TruncateKeysWithPrefix([]byte("\xff\xff"))
+	require.NoError(t, err)
+	require.Equal(t, 2, deleted)
+	require.Equal(t, []string{"a", "ac", "b", "\xfe\xff", "\xff", "\xff\x00"}, remaining())
+
+	deleted, err = tree.TruncateKeysWithPrefix([]byte("\xfe"))
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+
+	deleted, err = tree.TruncateKeysWithPrefix([]byte("z"))
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+
+	deleted, err = tree.TruncateKeysWithPrefix(nil)
+	require.NoError(t, err)
+	require.Equal(t, 5, deleted)
+	require.True(t, tree.IsEmpty())
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+}
+
+func TestMutableTree_ObserveHashes(t *testing.T) {
+	tree := setupMutableTree(t, false)
+	for i := 0; i < 100; i++ {
+		_, err := tree.Set([]byte(strconv.Itoa(i)), []byte{byte(i)})
+		require.NoError(t, err)
+	}
+
+	observed := map[string][2][]byte{}
+	tree.ObserveHashes(func(nodeHash, leftHash, rightHash []byte) {
+		observed[string(nodeHash)] = [2][]byte{leftHash, rightHash}
+	})
+	rootHash, err := tree.WorkingHash()
+	require.NoError(t, err)
+	require.Len(t, observed, 99)
+
+	// Recompute the root hash bottom-up, using only node metadata and the observed child hashes.
+	var rebuild func(node *Node) []byte
+	rebuild = func(node *Node) []byte {
+		var buf bytes.Buffer
+		require.NoError(t, encoding.EncodeVarint(&buf, int64(node.subtreeHeight)))
+		require.NoError(t, encoding.EncodeVarint(&buf, node.size))
+		require.NoError(t, encoding.EncodeVarint(&buf, node.version))
+		if node.isLeaf() {
+			valueHash := sha256.Sum256(node.value)
+			require.NoError(t, encoding.EncodeBytes(&buf, node.key))
+			require.NoError(t, encoding.EncodeBytes(&buf, valueHash[:]))
+		} else {
+			children, ok := observed[string(node.hash)]
+			require.True(t, ok, "inner node %X was not observed", node.hash)
+			leftNode, err := node.getLeftNode(tree.ImmutableTree)
+			require.NoError(t, err)
+			rightNode, err := node.getRightNode(tree.ImmutableTree)
+			require.NoError(t, err)
+			require.Equal(t, children[0], rebuild(leftNode))
+			require.Equal(t, children[1], rebuild(rightNode))
+			require.NoError(t, encoding.EncodeBytes(&buf, children[0]))
+			require.NoError(t, encoding.EncodeBytes(&buf, children[1]))
+		}
+		hash := sha256.Sum256(buf.Bytes())
+		return hash[:]
+	}
+	require.Equal(t, rootHash, rebuild(tree.root))
+
+	// Hashes computed while saving are observed too.
+	observed = map[string][2][]byte{}
+	_, err = tree.Set([]byte("new"), []byte("new"))
+	require.NoError(t, err)
+	rootHash, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	require.Contains(t, observed, string(rootHash))
+
+	tree.ObserveHashes(nil)
+	observed = map[string][2][]byte{}
+	_, err = tree.Set([]byte("newer"), []byte("newer"))
+	require.NoError(t, err)
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	require.Empty(t, observed)
+}
+
+func TestMutableTree_CountOrphans(t *testing.T) {
+	tree := setupMutableTree(t, false)
+	count, err := tree.CountOrphans()
+	require.NoError(t, err)
+	require.Zero(t, count)
+	size, err := tree.OrphanSizeBytes()
+	require.NoError(t, err)
+	require.Zero(t, size)
+
+	for v := 0; v < 5; v++ {
+		for i := 0; i < 20; i++ {
+			_, err := tree.Set([]byte{byte(i)}, []byte{byte(v)})
+			require.NoError(t, err)
+		}
+		_, _, err := tree.SaveVersion()
+		require.NoError(t, err)
+	}
+
+	orphans, err := tree.ndb.orphans()
+	require.NoError(t, err)
+	require.NotEmpty(t, orphans)
+	count, err = tree.CountOrphans()
+	require.NoError(t, err)
+	require.EqualValues(t, len(orphans), count)
+
+	size, err = tree.OrphanSizeBytes()
+	require.NoError(t, err)
+	require.Greater(t, size, count*int64(len(tree.ndb.orphanKey(0, 0, orphans[0]))))
+
+	require.NoError(t, tree.DeleteVersionsRange(1, 5))
+	remaining, err := tree.CountOrphans()
+	require.NoError(t, err)
+	require.Less(t, remaining, count)
+}
@@ -260,11 +260,32 @@ func (node *Node) _hash() ([]byte, error) {
 	return node.hash, nil
 }
 
+// verifyHash recomputes the hash of the node from its contents and reports whether it
+// matches the stored hash. Must be called on nodes which have their own hash and
+// descendant node hashes already computed.
+func (node *Node) verifyHash() (bool, error) {
+	h := sha256.New()
+	buf := new(bytes.Buffer)
+	if err := node.writeHashBytes(buf); err != nil {
+		return false, err
+	}
+	_, err := h.Write(buf.Bytes())
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(h.Sum(nil), node.hash), nil
+}
+
+// hashObserver is called with the hash of each inner node as it is computed,
+// along with the hashes of its children. See MutableTree.ObserveHashes().
+type hashObserver func(nodeHash, leftHash, rightHash []byte)
+
 // Hash the node and its descendants recursively. This usually mutates all
 // descendant nodes. Returns the node hash and number of nodes hashed.
 // If the tree is empty (i.e. the node is nil), returns the hash of an empty input,
-// to conform with RFC-6962.
-func (node *Node) hashWithCount() ([]byte, int64, error) {
+// to conform with RFC-6962. If observer is not nil, it is called for each inner
+// node hashed.
+func (node *Node) hashWithCount(observer hashObserver) ([]byte, int64, error) {
 	if node == nil {
 		return sha256.New().Sum(nil), 0, nil
 	}
@@ -274,7 +295,7 @@ func (node *Node) hashWithCount() ([]byte, int64, error) {
 
 	h := sha256.New()
 	buf := new(bytes.Buffer)
-	hashCount, err := node.writeHashBytesRecursively(buf)
+	hashCount, err := node.writeHashBytesRecursively(buf, observer)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -283,6 +304,9 @@ func (node *Node) hashWithCount() ([]byte, int64, error) {
 		return nil, 0, err
 	}
 	node.hash = h.Sum(nil)
+	if observer != nil && !node.isLeaf() {
+		observer(node.hash, node.leftHash, node.rightHash)
+	}
 
 	return node.hash, hashCount + 1, nil
 }
@@ -379,9 +403,9 @@ func (node *Node) writeHashBytes(w io.Writer) error {
 
 // Writes the node's hash to the given io.Writer.
 // This function has the side-effect of calling hashWithCount.
-func (node *Node) writeHashBytesRecursively(w io.Writer) (hashCount int64, err error) {
+func (node *Node) writeHashBytesRecursively(w io.Writer, observer hashObserver) (hashCount int64, err error) {
 	if node.leftNode != nil {
-		leftHash, leftCount, err := node.leftNode.hashWithCount()
+		leftHash, leftCount, err := node.leftNode.hashWithCount(observer)
 		if err != nil {
 			return 0, err
 		}
@@ -389,7 +413,7 @@ func (node *Node) writeHashBytesRecursively(w io.Writer) (hashCount int64, err e
 		hashCount += leftCount
 	}
 	if node.rightNode != nil {
-		rightHash, rightCount, err := node.rightNode.hashWithCount()
+		rightHash, rightCount, err := node.rightNode.hashWithCount(observer)
 		if err != nil {
 			return 0, err
 		}
@@ -3,6 +3,7 @@ package iavl
 import (
 	"bytes"
 	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
 	"math"
 	"sort"
@@ -24,6 +25,8 @@ const (
 	hashSize          = sha256.Size
 	genesisVersion    = 1
 	storageVersionKey = "storage_version"
+	// Sync deletes this metadata key, which is never set, to flush previous writes to disk.
+	syncMarkerKey = "sync_marker"
 	// We store latest saved version together with storage version delimited by the constant below.
 	// This delimiter is valid only if fast storage is enabled (i.e. storageVersion >= fastStorageVersionValue).
 	// The latest saved version is needed for protection against downgrade and re-upgrade. In such a case, it would
@@ -65,13 +68,28 @@ var (
 
 	// Root nodes are indexed separately by their version
 	rootKeyFormat = keyformat.NewKeyFormat('r', int64Size) // r<version>
+
+	// Version comments are optional labels attached to saved versions, indexed by version.
+	commentKeyFormat = keyformat.NewKeyFormat('c', int64Size) // c<version>
+
+	// Version tags are unique names of saved versions, indexed by tag. The value is the version.
+	tagKeyFormat = keyformat.NewKeyFormat('t', 0) // t<tag>
+
+	// Version tags are also indexed by version, so that they can be found and deleted with the
+	// version. The value is the tag.
+	versionTagKeyFormat = keyformat.NewKeyFormat('g', int64Size) // g<version>
+
+	// Historical versions are saved before the latest version by SaveVersionAs, so that the fast
+	// nodes don't reflect them. They are indexed by version, with an empty value.
+	historicalKeyFormat = keyformat.NewKeyFormat('h', int64Size) // h<version>
 )
 
 var errInvalidFastStorageVersion = fmt.Sprintf("Fast storage version must be in the format <storage version>%s<latest fast cache version>", fastStorageVersionDelimiter)
 
 type nodeDB struct {
 	mtx            sync.Mutex       // Read/write lock.
-	db             dbm.DB           // Persistent node storage.
+	db             dbm.DB           // Persistent node storage, wrapped by dbStats.
+	dbStats        *countingDB      // Counts the operations on the persistent node storage.
 	batch          dbm.Batch        // Batched writing buffer.
 	opts           Options          // Options to customize for pruning/writing
 	versionReaders map[int64]uint32 // Number of active version readers
@@ -79,6 +97,13 @@ type nodeDB struct {
 	latestVersion  int64            // Latest version of nodeDB.
 	nodeCache      cache.Cache      // Cache for nodes in the regular tree that consists of key-value pairs at any version.
 	fastNodeCache  cache.Cache      // Cache for nodes in the fast index that represents only key-value pairs at the latest version.
+	hotKeys        hotKeyTracker    // Access counts of the most frequently read keys.
+	hashObserver   hashObserver     // Called for each inner node hash computed, if set.
+
+	evictionListener  func(key []byte, node *Node)       // Called for each node evicted from nodeCache, if set.
+	evictionCallbacks map[string]func(key, value []byte) // Called once when the node with the given hash is evicted from nodeCache.
+	accessRecorder    func(hash []byte)                  // Called with the hash of each node read by GetNode, if set. Guarded by mtx.
+	keyMetrics        *keyMetricsTracker                 // Access metrics of the most recently accessed keys.
 }
 
 func newNodeDB(db dbm.DB, cacheSize int, opts *Options) *nodeDB {
@@ -87,6 +112,8 @@ func newNodeDB(db dbm.DB, cacheSize int, opts *Options) *nodeDB {
 		opts = &o
 	}
 
+	dbStats := newCountingDB(db)
+	db = dbStats
 	storeVersion, err := db.Get(metadataKeyFormat.Key(unsafeToBz(storageVersionKey)))
 
 	if err != nil || storeVersion == nil {
@@ -95,6 +122,7 @@ func newNodeDB(db dbm.DB, cacheSize int, opts *Options) *nodeDB {
 
 	return &nodeDB{
 		db:             db,
+		dbStats:        dbStats,
 		batch:          db.NewBatch(),
 		opts:           *opts,
 		latestVersion:  0, // initially invalid
@@ -102,14 +130,27 @@ func newNodeDB(db dbm.DB, cacheSize int, opts *Options) *nodeDB {
 		fastNodeCache:  cache.New(fastNodeCacheSize),
 		versionReaders: make(map[int64]uint32, 8),
 		storageVersion: string(storeVersion),
+		hotKeys:        newHotKeyTracker(opts.HotKeyTracking),
+		keyMetrics:     newKeyMetricsTracker(opts.KeyMetricsSize),
+
+		evictionCallbacks: map[string]func(key, value []byte){},
 	}
 }
 
 // GetNode gets a node from memory or disk. If it is an inner node, it does not
 // load its children.
 func (ndb *nodeDB) GetNode(hash []byte) (*Node, error) {
+	var recordAccess func(hash []byte)
+	defer func() { // after unlocking
+		if recordAccess != nil {
+			recordAccess(hash)
+		}
+	}()
+	var evicted *Node
+	defer func() { ndb.notifyEviction(evicted) }() // after unlocking
 	ndb.mtx.Lock()
 	defer ndb.mtx.Unlock()
+	recordAccess = ndb.accessRecorder
 
 	if len(hash) == 0 {
 		return nil, ErrNodeMissingHash
@@ -126,10 +167,10 @@ func (ndb *nodeDB) GetNode(hash []byte) (*Node, error) {
 	// Doesn't exist, load.
 	buf, err := ndb.db.Get(ndb.nodeKey(hash))
 	if err != nil {
-		return nil, fmt.Errorf("can't get node %X: %v", hash, err)
+		return nil, errors.Wrapf(err, "can't get node %X", hash)
 	}
 	if buf == nil {
-		return nil, fmt.Errorf("Value missing for hash %x corresponding to nodeKey %x", hash, ndb.nodeKey(hash))
+		return nil, errors.Wrapf(errNodeMissing, "hash %x corresponding to nodeKey %x", hash, ndb.nodeKey(hash))
 	}
 
 	node, err := MakeNode(buf)
@@ -139,11 +180,91 @@ func (ndb *nodeDB) GetNode(hash []byte) (*Node, error) {
 
 	node.hash = hash
 	node.persisted = true
-	ndb.nodeCache.Add(node)
+	evicted = ndb.addToNodeCache(node)
 
 	return node, nil
 }
 
+// touchNode marks the node with the given hash as most recently used in the node cache, if it
+// is cached.
+func (ndb *nodeDB) touchNode(hash []byte) {
+	if len(hash) == 0 {
+		return
+	}
+	ndb.mtx.Lock()
+	defer ndb.mtx.Unlock()
+	ndb.nodeCache.Get(hash)
+}
+
+// addToNodeCache adds a node to the node cache, and returns the node evicted to make room for
+// it, if any.
+func (ndb *nodeDB) addToNodeCache(node *Node) *Node {
+	evicted := ndb.nodeCache.Add(node)
+	if evicted == nil || bytes.Equal(evicted.GetKey(), node.GetKey()) {
+		// Replaced by the same node, or not cached at all.
+		return nil
+	}
+	return evicted.(*Node)
+}
+
+// notifyEviction calls the eviction callback of a node evicted from the node cache, if any, and
+// the eviction listener. It must be called without holding the lock, so that they can't deadlock
+// the nodeDB.
+func (ndb *nodeDB) notifyEviction(evicted *Node) {
+	if evicted == nil {
+		return
+	}
+	ndb.mtx.Lock()
+	onEvict, ok := ndb.evictionCallbacks[unsafeToStr(evicted.hash)]
+	if ok {
+		delete(ndb.evictionCallbacks, unsafeToStr(evicted.hash))
+	}
+	ndb.mtx.Unlock()
+
+	if onEvict != nil {
+		onEvict(evicted.key, evicted.value)
+	}
+	if ndb.evictionListener != nil {
+		ndb.evictionListener(evicted.hash, evicted)
+	}
+}
+
+// setEvictionCallback sets a callback which is called once the given saved node is evicted from
+// the node cache. If it was already evicted, e.g. while saving, the callback is called right away.
+func (ndb *nodeDB) setEvictionCallback(node *Node, onEvict func(key, value []byte)) {
+	ndb.mtx.Lock()
+	cached := ndb.nodeCache.Has(node.hash)
+	if cached {
+		ndb.evictionCallbacks[string(node.hash)] = onEvict
+	}
+	ndb.mtx.Unlock()
+
+	if !cached {
+		onEvict(node.key, node.value)
+	}
+}
+
+// setAccessRecorder sets the function called with the hash of each node read by GetNode, or
+// removes it if nil.
+func (ndb *nodeDB) setAccessRecorder(recordAccess func(hash []byte)) {
+	ndb.mtx.Lock()
+	defer ndb.mtx.Unlock()
+	ndb.accessRecorder = recordAccess
+}
+
+// uncacheNode removes a deleted node from the node cache, along with its eviction callback.
+func (ndb *nodeDB) uncacheNode(hash []byte) {
+	ndb.mtx.Lock()
+	defer ndb.mtx.Unlock()
+	ndb.uncacheNodeUnlocked(hash)
+}
+
+// uncacheNodeUnlocked is like uncacheNode, for callers already holding the lock.
+func (ndb *nodeDB) uncacheNodeUnlocked(hash []byte) {
+	ndb.nodeCache.Remove(hash)
+	delete(ndb.evictionCallbacks, unsafeToStr(hash))
+}
+
 func (ndb *nodeDB) GetFastNode(key []byte) (*fastnode.Node, error) {
 	if !ndb.hasUpgradedToFastStorage() {
 		return nil, errors.New("storage version is not fast")
@@ -158,10 +279,12 @@ func (ndb *nodeDB) GetFastNode(key []byte) (*fastnode.Node, error) {
 
 	if cachedFastNode := ndb.fastNodeCache.Get(key); cachedFastNode != nil {
 		ndb.opts.Stat.IncFastCacheHitCnt()
+		ndb.keyMetrics.recordCacheLookup(key, true)
 		return cachedFastNode.(*fastnode.Node), nil
 	}
 
 	ndb.opts.Stat.IncFastCacheMissCnt()
+	ndb.keyMetrics.recordCacheLookup(key, false)
 
 	// Doesn't exist, load.
 	buf, err := ndb.db.Get(ndb.fastNodeKey(key))
@@ -183,6 +306,8 @@ func (ndb *nodeDB) GetFastNode(key []byte) (*fastnode.Node, error) {
 
 // SaveNode saves a node to disk.
 func (ndb *nodeDB) SaveNode(node *Node) error {
+	var evicted *Node
+	defer func() { ndb.notifyEviction(evicted) }() // after unlocking
 	ndb.mtx.Lock()
 	defer ndb.mtx.Unlock()
 
@@ -206,7 +331,7 @@ func (ndb *nodeDB) SaveNode(node *Node) error {
 	}
 	logger.Debug("BATCH SAVE %X %p\n", node.hash, node)
 	node.persisted = true
-	ndb.nodeCache.Add(node)
+	evicted = ndb.addToNodeCache(node)
 	return nil
 }
 
@@ -312,7 +437,8 @@ func (ndb *nodeDB) saveFastNodeUnlocked(node *fastnode.Node, shouldAddToCache bo
 func (ndb *nodeDB) Has(hash []byte) (bool, error) {
 	key := ndb.nodeKey(hash)
 
-	if ldb, ok := ndb.db.(*dbm.GoLevelDB); ok {
+	if ldb, ok := ndb.dbStats.DB.(*dbm.GoLevelDB); ok {
+		ndb.dbStats.addRead(0)
 		exists, err := ldb.DB().Has(key, nil)
 		if err != nil {
 			return false, err
@@ -353,10 +479,14 @@ func (ndb *nodeDB) SaveBranch(node *Node) ([]byte, error) {
 		return nil, err
 	}
 
+	hashed := node.hash == nil
 	_, err = node._hash()
 	if err != nil {
 		return nil, err
 	}
+	if hashed && ndb.hashObserver != nil && !node.isLeaf() {
+		ndb.hashObserver(node.hash, node.leftHash, node.rightHash)
+	}
 
 	err = ndb.SaveNode(node)
 	if err != nil {
@@ -462,7 +592,7 @@ func (ndb *nodeDB) DeleteVersionsFrom(version int64) error {
 			if err = ndb.batch.Delete(ndb.nodeKey(hash)); err != nil {
 				return err
 			}
-			ndb.nodeCache.Remove(hash)
+			ndb.uncacheNode(hash)
 		} else if toVersion >= version-1 {
 			if err = ndb.batch.Delete(key); err != nil {
 				return err
@@ -487,6 +617,20 @@ func (ndb *nodeDB) DeleteVersionsFrom(version int64) error {
 		return err
 	}
 
+	// Delete the version comments and tags
+	err = ndb.deleteVersionComments(version, math.MaxInt64)
+	if err != nil {
+		return err
+	}
+	err = ndb.deleteVersionTags(version, math.MaxInt64)
+	if err != nil {
+		return err
+	}
+	err = ndb.deleteHistoricalVersions(version, math.MaxInt64)
+	if err != nil {
+		return err
+	}
+
 	// Delete fast node entries
 	err = ndb.traverseFastNodes(func(keyWithPrefix, v []byte) error {
 		key := keyWithPrefix[1:]
@@ -555,7 +699,7 @@ func (ndb *nodeDB) DeleteVersionsRange(fromVersion, toVersion int64) error {
 				if err := ndb.batch.Delete(ndb.nodeKey(hash)); err != nil {
 					return err
 				}
-				ndb.nodeCache.Remove(hash)
+				ndb.uncacheNodeUnlocked(hash)
 			} else {
 				if err := ndb.saveOrphan(hash, from, predecessor); err != nil {
 					return err
@@ -579,7 +723,13 @@ func (ndb *nodeDB) DeleteVersionsRange(fromVersion, toVersion int64) error {
 	if err != nil {
 		return err
 	}
-	return nil
+	if err := ndb.deleteVersionComments(fromVersion, toVersion); err != nil {
+		return err
+	}
+	if err := ndb.deleteHistoricalVersions(fromVersion, toVersion); err != nil {
+		return err
+	}
+	return ndb.deleteVersionTags(fromVersion, toVersion)
 }
 
 func (ndb *nodeDB) DeleteFastNode(key []byte) error {
@@ -620,7 +770,7 @@ func (ndb *nodeDB) deleteNodesFrom(version int64, hash []byte) error {
 			return err
 		}
 
-		ndb.nodeCache.Remove(hash)
+		ndb.uncacheNode(hash)
 	}
 
 	return nil
@@ -648,6 +798,22 @@ func (ndb *nodeDB) SaveOrphans(version int64, orphans map[string]int64) error {
 	return nil
 }
 
+// SaveOrphansUntil saves orphaned nodes to disk, which live until the given version included,
+// unlike SaveOrphans which ends their lifetime before it.
+// orphans: the fromVersion of each orphaned node, by hash
+func (ndb *nodeDB) SaveOrphansUntil(toVersion int64, orphans map[string]int64) error {
+	ndb.mtx.Lock()
+	defer ndb.mtx.Unlock()
+
+	for hash, fromVersion := range orphans {
+		logger.Debug("SAVEORPHAN %v-%v %X\n", fromVersion, toVersion, hash)
+		if err := ndb.saveOrphan([]byte(hash), fromVersion, toVersion); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Saves a single orphan to disk.
 func (ndb *nodeDB) saveOrphan(hash []byte, fromVersion, toVersion int64) error {
 	if fromVersion > toVersion {
@@ -693,7 +859,7 @@ func (ndb *nodeDB) deleteOrphans(version int64) error {
 			if err := ndb.batch.Delete(ndb.nodeKey(hash)); err != nil {
 				return err
 			}
-			ndb.nodeCache.Remove(hash)
+			ndb.uncacheNodeUnlocked(hash)
 		} else {
 			logger.Debug("MOVE predecessor:%v fromVersion:%v toVersion:%v %X\n", predecessor, fromVersion, toVersion, hash)
 			err := ndb.saveOrphan(hash, fromVersion, predecessor)
@@ -721,6 +887,22 @@ func (ndb *nodeDB) rootKey(version int64) []byte {
 	return rootKeyFormat.Key(version)
 }
 
+func (ndb *nodeDB) commentKey(version int64) []byte {
+	return commentKeyFormat.Key(version)
+}
+
+func (ndb *nodeDB) tagKey(tag string) []byte {
+	return tagKeyFormat.Key([]byte(tag))
+}
+
+func (ndb *nodeDB) versionTagKey(version int64) []byte {
+	return versionTagKeyFormat.Key(version)
+}
+
+func (ndb *nodeDB) historicalKey(version int64) []byte {
+	return historicalKeyFormat.Key(version)
+}
+
 func (ndb *nodeDB) getLatestVersion() (int64, error) {
 	if ndb.latestVersion == 0 {
 		var err error
@@ -779,7 +961,20 @@ func (ndb *nodeDB) deleteRoot(version int64, checkLatestVersion bool) error {
 	if err := ndb.batch.Delete(ndb.rootKey(version)); err != nil {
 		return err
 	}
-	return nil
+	if err := ndb.batch.Delete(ndb.commentKey(version)); err != nil {
+		return err
+	}
+	if err := ndb.batch.Delete(ndb.historicalKey(version)); err != nil {
+		return err
+	}
+	tag, err := ndb.getVersionTag(version)
+	if err != nil || tag == "" {
+		return err
+	}
+	if err := ndb.batch.Delete(ndb.tagKey(tag)); err != nil {
+		return err
+	}
+	return ndb.batch.Delete(ndb.versionTagKey(version))
 }
 
 // Traverse orphans and return error if any, nil otherwise
@@ -886,6 +1081,14 @@ func (ndb *nodeDB) Commit() error {
 	return nil
 }
 
+// Sync flushes all previous writes of the underlying database to disk. Since dbm.DB has
+// no explicit fsync, this is done by deleting a marker key, which is never set, with DeleteSync.
+func (ndb *nodeDB) Sync() error {
+	ndb.mtx.Lock()
+	defer ndb.mtx.Unlock()
+	return errors.Wrap(ndb.db.DeleteSync(metadataKeyFormat.Key([]byte(syncMarkerKey))), "failed to sync")
+}
+
 func (ndb *nodeDB) HasRoot(version int64) (bool, error) {
 	return ndb.db.Has(ndb.rootKey(version))
 }
@@ -905,6 +1108,17 @@ func (ndb *nodeDB) getRoots() (roots map[int64][]byte, err error) {
 	return roots, err
 }
 
+// getVersions returns the saved versions in ascending order, as found in the database.
+func (ndb *nodeDB) getVersions() (versions []int64, err error) {
+	err = ndb.traversePrefix(rootKeyFormat.Key(), func(k, v []byte) error {
+		var version int64
+		rootKeyFormat.Scan(k, &version)
+		versions = append(versions, version)
+		return nil
+	})
+	return versions, err
+}
+
 // SaveRoot creates an entry on disk for the given root, so that it can be
 // loaded later.
 func (ndb *nodeDB) SaveRoot(root *Node, version int64) error {
@@ -914,6 +1128,111 @@ func (ndb *nodeDB) SaveRoot(root *Node, version int64) error {
 	return ndb.saveRoot(root.hash, version)
 }
 
+// SaveVersionComment sets the comment of the given version. Requires changes to be committed
+// after to be persisted.
+func (ndb *nodeDB) SaveVersionComment(version int64, comment string) error {
+	ndb.mtx.Lock()
+	defer ndb.mtx.Unlock()
+	return ndb.batch.Set(ndb.commentKey(version), []byte(comment))
+}
+
+// getVersionComment returns the comment of the given version, or an empty string if none.
+func (ndb *nodeDB) getVersionComment(version int64) (string, error) {
+	comment, err := ndb.db.Get(ndb.commentKey(version))
+	if err != nil {
+		return "", err
+	}
+	return string(comment), nil
+}
+
+// deleteVersionComments deletes the comments of the versions from fromVersion (inclusive) to
+// toVersion (exclusive).
+func (ndb *nodeDB) deleteVersionComments(fromVersion, toVersion int64) error {
+	return ndb.traverseRange(commentKeyFormat.Key(fromVersion), commentKeyFormat.Key(toVersion), func(k, v []byte) error {
+		return ndb.batch.Delete(k)
+	})
+}
+
+// SaveHistoricalVersion marks the given version as saved before the latest version. Requires
+// changes to be committed after to be persisted.
+func (ndb *nodeDB) SaveHistoricalVersion(version int64) error {
+	ndb.mtx.Lock()
+	defer ndb.mtx.Unlock()
+	return ndb.batch.Set(ndb.historicalKey(version), []byte{})
+}
+
+// isHistoricalVersion returns whether the given version was saved before the latest version.
+func (ndb *nodeDB) isHistoricalVersion(version int64) (bool, error) {
+	return ndb.db.Has(ndb.historicalKey(version))
+}
+
+// deleteHistoricalVersions deletes the historical version marks of the versions from
+// fromVersion (inclusive) to toVersion (exclusive).
+func (ndb *nodeDB) deleteHistoricalVersions(fromVersion, toVersion int64) error {
+	return ndb.traverseRange(historicalKeyFormat.Key(fromVersion), historicalKeyFormat.Key(toVersion), func(k, v []byte) error {
+		return ndb.batch.Delete(k)
+	})
+}
+
+// SaveVersionTag sets the version of the given tag. Requires changes to be committed after to be
+// persisted.
+func (ndb *nodeDB) SaveVersionTag(tag string, version int64) error {
+	ndb.mtx.Lock()
+	defer ndb.mtx.Unlock()
+	value := make([]byte, int64Size)
+	binary.BigEndian.PutUint64(value, uint64(version))
+	if err := ndb.batch.Set(ndb.tagKey(tag), value); err != nil {
+		return err
+	}
+	return ndb.batch.Set(ndb.versionTagKey(version), []byte(tag))
+}
+
+// DeleteVersionTag deletes the given tag. Requires changes to be committed after to be persisted.
+func (ndb *nodeDB) DeleteVersionTag(tag string) error {
+	version, err := ndb.getVersionByTag(tag)
+	if err != nil || version == 0 {
+		return err
+	}
+	ndb.mtx.Lock()
+	defer ndb.mtx.Unlock()
+	if err := ndb.batch.Delete(ndb.tagKey(tag)); err != nil {
+		return err
+	}
+	return ndb.batch.Delete(ndb.versionTagKey(version))
+}
+
+// getVersionByTag returns the version of the given tag, or 0 if there is no such tag.
+func (ndb *nodeDB) getVersionByTag(tag string) (int64, error) {
+	value, err := ndb.db.Get(ndb.tagKey(tag))
+	if err != nil || value == nil {
+		return 0, err
+	}
+	if len(value) != int64Size {
+		return 0, errors.Errorf("invalid version of tag %q: %X", tag, value)
+	}
+	return int64(binary.BigEndian.Uint64(value)), nil
+}
+
+// getVersionTag returns the tag of the given version, or an empty string if it has none.
+func (ndb *nodeDB) getVersionTag(version int64) (string, error) {
+	tag, err := ndb.db.Get(ndb.versionTagKey(version))
+	if err != nil {
+		return "", err
+	}
+	return string(tag), nil
+}
+
+// deleteVersionTags deletes the tags of the versions from fromVersion (inclusive) to toVersion
+// (exclusive).
+func (ndb *nodeDB) deleteVersionTags(fromVersion, toVersion int64) error {
+	return ndb.traverseRange(ndb.versionTagKey(fromVersion), ndb.versionTagKey(toVersion), func(k, v []byte) error {
+		if err := ndb.batch.Delete(ndb.tagKey(string(v))); err != nil {
+			return err
+		}
+		return ndb.batch.Delete(k)
+	})
+}
+
 // SaveEmptyRoot creates an entry on disk for an empty root.
 func (ndb *nodeDB) SaveEmptyRoot(version int64) error {
 	return ndb.saveRoot([]byte{}, version)
@@ -931,7 +1250,27 @@ func (ndb *nodeDB) saveRoot(hash []byte, version int64) error {
 	if latest > 0 && version != latest+1 {
 		return fmt.Errorf("must save consecutive versions; expected %d, got %d", latest+1, version)
 	}
+	return ndb.setRoot(hash, version)
+}
+
+// SaveRootAt saves the root of a version which need not follow the latest version, unlike
+// SaveRoot. A nil root saves an empty root.
+func (ndb *nodeDB) SaveRootAt(root *Node, version int64) error {
+	hash := []byte{}
+	if root != nil {
+		if len(root.hash) == 0 {
+			return ErrRootMissingHash
+		}
+		hash = root.hash
+	}
+
+	ndb.mtx.Lock()
+	defer ndb.mtx.Unlock()
+	return ndb.setRoot(hash, version)
+}
 
+// setRoot writes the root entry of a version. The caller must hold the lock.
+func (ndb *nodeDB) setRoot(hash []byte, version int64) error {
 	if err := ndb.batch.Set(ndb.rootKey(version), hash); err != nil {
 		return err
 	}
@@ -950,8 +1289,10 @@ func (ndb *nodeDB) incrVersionReaders(version int64) {
 func (ndb *nodeDB) decrVersionReaders(version int64) {
 	ndb.mtx.Lock()
 	defer ndb.mtx.Unlock()
-	if ndb.versionReaders[version] > 0 {
+	if ndb.versionReaders[version] > 1 {
 		ndb.versionReaders[version]--
+	} else {
+		delete(ndb.versionReaders, version)
 	}
 }
 
@@ -1004,6 +1345,34 @@ func (ndb *nodeDB) orphans() ([][]byte, error) {
 	return orphans, nil
 }
 
+// countOrphans returns the number of orphan records.
+func (ndb *nodeDB) countOrphans() (int64, error) {
+	var count int64
+	err := ndb.traverseOrphans(func(k, v []byte) error {
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// orphanSizeBytes returns the size of all orphan records, plus the size of the nodes they
+// refer to which are still stored.
+func (ndb *nodeDB) orphanSizeBytes() (int64, error) {
+	var size int64
+	err := ndb.traverseOrphans(func(k, hash []byte) error {
+		size += int64(len(k) + len(hash))
+		node, err := ndb.db.Get(ndb.nodeKey(hash))
+		if err != nil {
+			return err
+		}
+		if node != nil {
+			size += int64(len(ndb.nodeKey(hash)) + len(node))
+		}
+		return nil
+	})
+	return size, err
+}
+
 // Not efficient.
 // NOTE: DB cannot implement Size() because
 // mutations are not always synchronous.
@@ -1106,3 +1475,6 @@ var (
 	ErrNodeAlreadyPersisted = fmt.Errorf("shouldn't be calling save on an already persisted node")
 	ErrRootMissingHash      = fmt.Errorf("root hash must not be empty")
 )
+
+// errNodeMissing is returned by GetNode when no node with the requested hash is stored.
+var errNodeMissing = errors.New("node value missing")
@@ -293,3 +293,40 @@ func makeAndPopulateMutableTree(tb testing.TB) *MutableTree {
 	require.Nil(tb, err, "Expected .SaveVersion to succeed")
 	return tree
 }
+
+func TestSync_MemDB(t *testing.T) {
+	memDB := db.NewMemDB()
+	ndb := newNodeDB(memDB, 0, nil)
+	require.NoError(t, ndb.Sync())
+
+	// The storage version is left as is.
+	value, err := memDB.Get(metadataKeyFormat.Key([]byte(storageVersionKey)))
+	require.NoError(t, err)
+	require.Nil(t, value)
+}
+
+func TestSync_DeletesMarker(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	dbMock := mock.NewMockDB(ctrl)
+
+	storageKey := metadataKeyFormat.Key([]byte(storageVersionKey))
+	dbMock.EXPECT().Get(storageKey).Return([]byte(fastStorageVersionValue), nil).Times(1)
+	dbMock.EXPECT().NewBatch().Return(nil).Times(1)
+	dbMock.EXPECT().DeleteSync(metadataKeyFormat.Key([]byte(syncMarkerKey))).Return(nil).Times(1)
+
+	ndb := newNodeDB(dbMock, 0, nil)
+	require.NoError(t, ndb.Sync())
+}
+
+func TestSync_DBFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	dbMock := mock.NewMockDB(ctrl)
+
+	storageKey := metadataKeyFormat.Key([]byte(storageVersionKey))
+	dbMock.EXPECT().Get(storageKey).Return(nil, nil).Times(1)
+	dbMock.EXPECT().NewBatch().Return(nil).Times(1)
+	dbMock.EXPECT().DeleteSync(metadataKeyFormat.Key([]byte(syncMarkerKey))).Return(errors.New("some db error")).Times(1)
+
+	ndb := newNodeDB(dbMock, 0, nil)
+	require.Error(t, ndb.Sync())
+}
@@ -81,6 +81,17 @@ type Options struct {
 
 	// When Stat is not nil, statistical logic needs to be executed
 	Stat *Statistics
+
+	// HotKeyTracking is the number of most frequently read keys to track, see
+	// MutableTree.HotKeys(). Tracking is disabled when it is 0.
+	HotKeyTracking int
+
+	// KeyMetricsSize is the maximum number of keys with access metrics tracked, see
+	// MutableTree.PerKeyMetrics(). The least recently accessed keys are evicted beyond it. It
+	// defaults to DefaultKeyMetricsSize when 0, and tracking is disabled when it is negative.
+	// Tracking takes a global lock on every read and write of a key, and copies keys which are
+	// not tracked yet, so it should be disabled where that cost matters.
+	KeyMetricsSize int
 }
 
 // DefaultOptions returns the default options for IAVL.
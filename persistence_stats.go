@@ -0,0 +1,162 @@
+package iavl
+
+import (
+	"sync/atomic"
+
+	dbm "github.com/cosmos/cosmos-db"
+)
+
+// PersistenceStats holds the number of operations made by a tree on its database. Writes are
+// counted as they are added to a batch. Each Get and Has is one read, and iterators count one
+// read when created and one each time they are advanced.
+type PersistenceStats struct {
+	DBReads        int64
+	DBWrites       int64
+	DBBytesRead    int64
+	DBBytesWritten int64
+}
+
+// countingDB wraps a database to count the reads and writes made through it, including those of
+// its batches and iterators.
+type countingDB struct {
+	dbm.DB
+	reads        int64
+	writes       int64
+	bytesRead    int64
+	bytesWritten int64
+}
+
+var _ dbm.DB = (*countingDB)(nil)
+
+func newCountingDB(db dbm.DB) *countingDB {
+	return &countingDB{DB: db}
+}
+
+func (db *countingDB) addRead(bytes int) {
+	atomic.AddInt64(&db.reads, 1)
+	atomic.AddInt64(&db.bytesRead, int64(bytes))
+}
+
+func (db *countingDB) addWrite(bytes int) {
+	atomic.AddInt64(&db.writes, 1)
+	atomic.AddInt64(&db.bytesWritten, int64(bytes))
+}
+
+// stats returns the operations counted since creation or the last reset.
+func (db *countingDB) stats() PersistenceStats {
+	return PersistenceStats{
+		DBReads:        atomic.LoadInt64(&db.reads),
+		DBWrites:       atomic.LoadInt64(&db.writes),
+		DBBytesRead:    atomic.LoadInt64(&db.bytesRead),
+		DBBytesWritten: atomic.LoadInt64(&db.bytesWritten),
+	}
+}
+
+func (db *countingDB) reset() {
+	atomic.StoreInt64(&db.reads, 0)
+	atomic.StoreInt64(&db.writes, 0)
+	atomic.StoreInt64(&db.bytesRead, 0)
+	atomic.StoreInt64(&db.bytesWritten, 0)
+}
+
+func (db *countingDB) Get(key []byte) ([]byte, error) {
+	value, err := db.DB.Get(key)
+	db.addRead(len(value))
+	return value, err
+}
+
+func (db *countingDB) Has(key []byte) (bool, error) {
+	db.addRead(0)
+	return db.DB.Has(key)
+}
+
+func (db *countingDB) Set(key, value []byte) error {
+	db.addWrite(len(key) + len(value))
+	return db.DB.Set(key, value)
+}
+
+func (db *countingDB) SetSync(key, value []byte) error {
+	db.addWrite(len(key) + len(value))
+	return db.DB.SetSync(key, value)
+}
+
+func (db *countingDB) Delete(key []byte) error {
+	db.addWrite(len(key))
+	return db.DB.Delete(key)
+}
+
+func (db *countingDB) DeleteSync(key []byte) error {
+	db.addWrite(len(key))
+	return db.DB.DeleteSync(key)
+}
+
+func (db *countingDB) Iterator(start, end []byte) (dbm.Iterator, error) {
+	db.addRead(0)
+	iter, err := db.DB.Iterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return &countingIterator{Iterator: iter, db: db}, nil
+}
+
+func (db *countingDB) ReverseIterator(start, end []byte) (dbm.Iterator, error) {
+	db.addRead(0)
+	iter, err := db.DB.ReverseIterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return &countingIterator{Iterator: iter, db: db}, nil
+}
+
+func (db *countingDB) NewBatch() dbm.Batch {
+	return &countingBatch{Batch: db.DB.NewBatch(), db: db}
+}
+
+// countingBatch counts the writes added to a batch of a countingDB.
+type countingBatch struct {
+	dbm.Batch
+	db *countingDB
+}
+
+func (b *countingBatch) Set(key, value []byte) error {
+	b.db.addWrite(len(key) + len(value))
+	return b.Batch.Set(key, value)
+}
+
+func (b *countingBatch) Delete(key []byte) error {
+	b.db.addWrite(len(key))
+	return b.Batch.Delete(key)
+}
+
+// countingIterator counts the steps of an iterator of a countingDB, and the bytes read from it.
+// The key and value of each position are counted once, however many times they are read.
+type countingIterator struct {
+	dbm.Iterator
+	db           *countingDB
+	keyCounted   bool
+	valueCounted bool
+}
+
+func (iter *countingIterator) Next() {
+	iter.db.addRead(0)
+	iter.Iterator.Next()
+	iter.keyCounted, iter.valueCounted = false, false
+}
+
+func (iter *countingIterator) Key() []byte {
+	key := iter.Iterator.Key()
+	if !iter.keyCounted {
+		iter.keyCounted = true
+		atomic.AddInt64(&iter.db.bytesRead, int64(len(key)))
+	}
+	return key
+}
+
+func (iter *countingIterator) Value() []byte {
+	value := iter.Iterator.Value()
+	if !iter.valueCounted {
+		iter.valueCounted = true
+		atomic.AddInt64(&iter.db.bytesRead, int64(len(value)))
+	}
+	return value
+}
@@ -0,0 +1,75 @@
+package iavl
+
+import (
+	"testing"
+
+	db "github.com/cosmos/cosmos-db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountingDB(t *testing.T) {
+	counted := newCountingDB(db.NewMemDB())
+
+	require.NoError(t, counted.Set([]byte("a"), []byte("1")))
+	batch := counted.NewBatch()
+	require.NoError(t, batch.Set([]byte("b"), []byte("22")))
+	require.NoError(t, batch.Set([]byte("c"), []byte("333")))
+	require.NoError(t, batch.Delete([]byte("a")))
+	require.NoError(t, batch.Write())
+	require.NoError(t, batch.Close())
+	require.Equal(t, PersistenceStats{DBWrites: 4, DBBytesWritten: 10}, counted.stats())
+
+	value, err := counted.Get([]byte("b"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("22"), value)
+	has, err := counted.Has([]byte("a"))
+	require.NoError(t, err)
+	require.False(t, has)
+	iter, err := counted.Iterator(nil, nil)
+	require.NoError(t, err)
+	for ; iter.Valid(); iter.Next() {
+		iter.Key()
+		iter.Key()
+		iter.Value()
+	}
+	require.NoError(t, iter.Close())
+	// 2 reads for Get and Has, 1 for the iterator and 2 for its steps. The bytes of each position
+	// are counted once.
+	require.Equal(t, PersistenceStats{DBReads: 5, DBWrites: 4, DBBytesRead: 9, DBBytesWritten: 10}, counted.stats())
+
+	counted.reset()
+	require.Equal(t, PersistenceStats{}, counted.stats())
+}
+
+func TestMutableTree_PersistenceStats(t *testing.T) {
+	memDB := db.NewMemDB()
+	tree, err := NewMutableTree(memDB, 0, true)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err := tree.Set([]byte{byte(i)}, []byte{byte(i)})
+		require.NoError(t, err)
+	}
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+
+	stats := tree.PersistenceStats()
+	// 19 nodes and the root were written.
+	require.EqualValues(t, 20, stats.DBWrites)
+	require.Positive(t, stats.DBBytesWritten)
+
+	tree.ResetPersistenceStats()
+	require.Equal(t, PersistenceStats{}, tree.PersistenceStats())
+
+	reloaded, err := NewMutableTree(memDB, 0, true)
+	require.NoError(t, err)
+	_, err = reloaded.Load()
+	require.NoError(t, err)
+	reloaded.ResetPersistenceStats()
+	value, err := reloaded.Get([]byte{5})
+	require.NoError(t, err)
+	require.Equal(t, []byte{5}, value)
+	stats = reloaded.PersistenceStats()
+	// The root is already loaded, so only the 3 nodes below it on the path to the key are read.
+	require.EqualValues(t, 3, stats.DBReads)
+	require.Zero(t, stats.DBWrites)
+}
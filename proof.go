@@ -278,3 +278,39 @@ func (node *Node) pathToLeaf(t *ImmutableTree, key []byte, path *PathToLeaf) (*N
 	n, err := rightNode.pathToLeaf(t, key, path)
 	return n, err
 }
+
+// pathToLeafByIndex constructs the PathToLeaf of the leaf with the given 0-based index, in the
+// same way as pathToLeaf(). The index must be within the subtree.
+func (node *Node) pathToLeafByIndex(t *ImmutableTree, index int64, path *PathToLeaf) (*Node, error) {
+	if node.subtreeHeight == 0 {
+		return node, nil
+	}
+
+	leftNode, err := node.getLeftNode(t)
+	if err != nil {
+		return nil, err
+	}
+	rightNode, err := node.getRightNode(t)
+	if err != nil {
+		return nil, err
+	}
+
+	if index < leftNode.size {
+		*path = append(*path, ProofInnerNode{
+			Height:  node.subtreeHeight,
+			Size:    node.size,
+			Version: node.version,
+			Left:    nil,
+			Right:   rightNode.hash,
+		})
+		return leftNode.pathToLeafByIndex(t, index, path)
+	}
+	*path = append(*path, ProofInnerNode{
+		Height:  node.subtreeHeight,
+		Size:    node.size,
+		Version: node.version,
+		Left:    leftNode.hash,
+		Right:   nil,
+	})
+	return rightNode.pathToLeafByIndex(t, index-leftNode.size, path)
+}
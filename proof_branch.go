@@ -0,0 +1,215 @@
+package iavl
+
+import (
+	"bytes"
+	"crypto/sha256"
+
+	dbm "github.com/cosmos/cosmos-db"
+	"github.com/pkg/errors"
+)
+
+// ErrNotInProof is returned when querying parts of a tree built by NewBranchFromProof() which
+// were not covered by the proof.
+var ErrNotInProof = errors.New("not covered by proof")
+
+// NewBranchFromProof builds a read-only tree from a range proof and the proven key/value pairs,
+// as returned by GetRangeWithProof(). Since proofs only contain value hashes, the values must be
+// given separately, and each key must be a leaf of the proof. The proof and values are verified
+// against root, and the returned tree has the same root hash. The tree is backed by a MemDB.
+//
+// Proof leaves without a given value, and subtrees which were not covered by the proof, are only
+// known by their hash, and queries which need them return an error wrapping ErrNotInProof.
+func NewBranchFromProof(root []byte, proof *RangeProof, keys, values [][]byte) (*ImmutableTree, error) {
+	if proof == nil || len(proof.Leaves) == 0 {
+		return nil, errors.Wrap(ErrInvalidProof, "proof has no leaves")
+	}
+	if len(keys) == 0 || len(keys) != len(values) {
+		return nil, errors.Wrapf(ErrInvalidInputs, "got %d keys and %d values", len(keys), len(values))
+	}
+	if err := proof.Verify(root); err != nil {
+		return nil, err
+	}
+
+	leafValues := make(map[string][]byte, len(keys))
+	for _, leaf := range proof.Leaves {
+		leafValues[string(leaf.Key)] = nil
+	}
+	for i, key := range keys {
+		if _, ok := leafValues[string(key)]; !ok {
+			return nil, errors.Wrapf(ErrInvalidInputs, "key %X is not a leaf of the proof", key)
+		}
+		value := values[i]
+		if value == nil {
+			value = []byte{}
+		}
+		leafValues[string(key)] = value
+	}
+
+	b := &branchBuilder{leaves: proof.Leaves, values: leafValues, innerPaths: proof.InnerNodes}
+	rootNode, _, err := b.build(proof.LeftPath)
+	if err != nil {
+		return nil, err
+	}
+	setInnerKeys(rootNode)
+
+	hash, _, err := rootNode.hashWithCount(nil)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(hash, root) {
+		return nil, errors.Wrapf(ErrInvalidRoot, "rebuilt root hash %X does not match %X", hash, root)
+	}
+	if rootNode.isLeaf() && rootNode.value == nil {
+		return nil, errors.Wrap(ErrInvalidInputs, "no value given for the proof leaf")
+	}
+	pruneLeaves(rootNode)
+
+	ndb := newNodeDB(&proofDB{DB: dbm.NewMemDB()}, 0, nil)
+	if _, err := ndb.SaveBranch(rootNode); err != nil {
+		return nil, err
+	}
+	if err := ndb.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &ImmutableTree{
+		root:                   rootNode,
+		ndb:                    ndb,
+		version:                rootNode.version,
+		skipFastStorageUpgrade: true,
+	}, nil
+}
+
+// branchBuilder rebuilds the nodes proven by a RangeProof, following the same traversal as
+// RangeProof._computeRootHash(). Children which are not covered by the proof are left as hashes
+// only. Leaves without a value are built with only their hash, and pruned by pruneLeaves().
+type branchBuilder struct {
+	leaves     []ProofLeafNode
+	values     map[string][]byte
+	innerPaths []PathToLeaf
+}
+
+// build builds the subtree along path to the next leaf, and the subtrees to the right of the
+// path for all subsequent leaves. It returns the subtree root, and whether all leaves are built.
+func (b *branchBuilder) build(path PathToLeaf) (*Node, bool, error) {
+	leaf := b.leaves[0]
+	b.leaves = b.leaves[1:]
+	child := &Node{
+		key:     leaf.Key,
+		value:   b.values[string(leaf.Key)],
+		version: leaf.Version,
+		size:    1,
+	}
+	if child.value != nil {
+		valueHash := sha256.Sum256(child.value)
+		if !bytes.Equal(valueHash[:], leaf.ValueHash) {
+			return nil, false, errors.Wrapf(ErrInvalidInputs, "value of key %X does not match proof", leaf.Key)
+		}
+	} else {
+		hash, err := leaf.Hash()
+		if err != nil {
+			return nil, false, err
+		}
+		child.hash = hash
+	}
+
+	nodes := make([]*Node, len(path))
+	for i := len(path) - 1; i >= 0; i-- {
+		inner := path[i]
+		node := &Node{
+			subtreeHeight: inner.Height,
+			size:          inner.Size,
+			version:       inner.Version,
+		}
+		if len(inner.Left) == 0 {
+			node.leftNode, node.rightHash = child, inner.Right
+		} else {
+			node.leftHash, node.rightNode = inner.Left, child
+		}
+		nodes[i] = node
+		child = node
+	}
+
+	// Build the subtrees right of the path, from the bottom up, as long as there are leaves.
+	for i := len(path) - 1; i >= 0; i-- {
+		if len(path[i].Right) == 0 {
+			continue
+		}
+		if len(b.leaves) == 0 {
+			return child, true, nil
+		}
+		innerPath := b.innerPaths[0]
+		b.innerPaths = b.innerPaths[1:]
+		right, done, err := b.build(innerPath)
+		if err != nil {
+			return nil, false, err
+		}
+		nodes[i].rightNode, nodes[i].rightHash = right, nil
+		if done {
+			return child, true, nil
+		}
+	}
+	return child, len(b.leaves) == 0, nil
+}
+
+// setInnerKeys sets the keys of the inner nodes of a branch. The key of an inner node is the
+// first key of its right subtree, or if that is not known, the smallest key after its left
+// subtree. Returns the first and last known key of the subtree.
+func setInnerKeys(node *Node) (first, last []byte) {
+	if node.isLeaf() {
+		return node.key, node.key
+	}
+	var leftFirst, leftLast, rightFirst, rightLast []byte
+	if node.leftNode != nil {
+		leftFirst, leftLast = setInnerKeys(node.leftNode)
+	}
+	if node.rightNode != nil {
+		rightFirst, rightLast = setInnerKeys(node.rightNode)
+		node.key = rightFirst
+	} else {
+		node.key = append(cp(leftLast), 0x00)
+	}
+
+	if leftFirst == nil {
+		return rightFirst, rightLast
+	}
+	if rightLast == nil {
+		return leftFirst, leftLast
+	}
+	return leftFirst, rightLast
+}
+
+// pruneLeaves replaces the leaves of a hashed branch which have no value with their hash.
+func pruneLeaves(node *Node) {
+	if node.isLeaf() {
+		return
+	}
+	if node.leftNode != nil {
+		if node.leftNode.isLeaf() && node.leftNode.value == nil {
+			node.leftHash, node.leftNode = node.leftNode.hash, nil
+		} else {
+			pruneLeaves(node.leftNode)
+		}
+	}
+	if node.rightNode != nil {
+		if node.rightNode.isLeaf() && node.rightNode.value == nil {
+			node.rightHash, node.rightNode = node.rightNode.hash, nil
+		} else {
+			pruneLeaves(node.rightNode)
+		}
+	}
+}
+
+// proofDB is a database holding the nodes of a branch built from a proof, which reports missing
+// nodes as ErrNotInProof.
+type proofDB struct {
+	dbm.DB
+}
+
+func (db *proofDB) Get(key []byte) ([]byte, error) {
+	value, err := db.DB.Get(key)
+	if err == nil && value == nil && bytes.HasPrefix(key, nodeKeyFormat.Key()) {
+		return nil, ErrNotInProof
+	}
+	return value, err
+}
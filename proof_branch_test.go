@@ -0,0 +1,79 @@
+package iavl
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBranchFromProof(t *testing.T) {
+	tree := setupMutableTree(t, false)
+	for i := 0; i < 100; i += 2 {
+		key := []byte(fmt.Sprintf("k%03d", i))
+		_, err := tree.Set(key, []byte(fmt.Sprintf("v%03d", i)))
+		require.NoError(t, err)
+	}
+	root, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+	itree, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+
+	keys, values, proof, err := itree.GetRangeWithProof([]byte("k020"), []byte("k040"), 0)
+	require.NoError(t, err)
+	require.Len(t, keys, 10)
+
+	branch, err := NewBranchFromProof(root, proof, keys, values)
+	require.NoError(t, err)
+	hash, err := branch.Hash()
+	require.NoError(t, err)
+	require.Equal(t, root, hash)
+
+	for i, key := range keys {
+		value, err := branch.Get(key)
+		require.NoError(t, err)
+		require.Equal(t, values[i], value)
+	}
+	value, err := branch.Get([]byte("k021"))
+	require.NoError(t, err)
+	require.Nil(t, value)
+
+	// The proof includes the leaf at the end key, but its value was not given.
+	_, err = branch.Get([]byte("k040"))
+	require.ErrorIs(t, err, ErrNotInProof)
+	_, err = branch.Get([]byte("k090"))
+	require.ErrorIs(t, err, ErrNotInProof)
+	_, err = branch.Get([]byte("k002"))
+	require.ErrorIs(t, err, ErrNotInProof)
+
+	// The values and root must match the proof.
+	badValues := append([][]byte{}, values...)
+	badValues[3] = []byte("bad")
+	_, err = NewBranchFromProof(root, proof, keys, badValues)
+	require.ErrorIs(t, err, ErrInvalidInputs)
+	_, err = NewBranchFromProof(root, proof, keys, values[1:])
+	require.ErrorIs(t, err, ErrInvalidInputs)
+	_, err = NewBranchFromProof(root, proof, [][]byte{[]byte("k021")}, [][]byte{{}})
+	require.ErrorIs(t, err, ErrInvalidInputs)
+	_, err = NewBranchFromProof([]byte("bad root"), proof, keys, values)
+	require.Error(t, err)
+}
+
+func TestNewBranchFromProof_Single(t *testing.T) {
+	tree, _ := getRandomizedTreeAndMirror(t)
+	root, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+	itree, err := tree.GetImmutable(tree.Version())
+	require.NoError(t, err)
+
+	key, err := itree.FirstKey()
+	require.NoError(t, err)
+	value, proof, err := itree.GetWithProof(key)
+	require.NoError(t, err)
+
+	branch, err := NewBranchFromProof(root, proof, [][]byte{key}, [][]byte{value})
+	require.NoError(t, err)
+	actual, err := branch.Get(key)
+	require.NoError(t, err)
+	require.Equal(t, value, actual)
+}
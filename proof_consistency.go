@@ -0,0 +1,123 @@
+package iavl
+
+import (
+	"bytes"
+	"crypto/sha256"
+
+	"github.com/pkg/errors"
+
+	iavlproto "github.com/cosmos/iavl/proto"
+)
+
+// ErrNotAdditionsOnly is returned when a version changes or removes keys of an earlier version.
+var ErrNotAdditionsOnly = errors.New("keys were changed or removed")
+
+// MerkleConsistencyProof returns a proof that the saved version v2 only adds keys to the saved
+// version v1: every key of v1 still exists in v2, with the same value. A verifier holding the
+// root hash of v1 checks the proof, and computes the root hash of v2, with
+// VerifyMerkleConsistencyProof. Returns ErrNotAdditionsOnly if v2 changes or removes keys of v1.
+//
+// Unlike the trees of RFC 6962 logs, an IAVL tree is not a fixed, left-filled tree: adding keys
+// rotates and rebuilds existing inner nodes, and inner node hashes commit to their height, size
+// and version. The subtrees of v1 don't reappear in v2, so no list of subtree hashes can prove
+// that one was derived from the other. Instead, the proof holds a range proof of all the keys of
+// v1, and one of all the keys of v2, each encoded as an iavlproto.RangeProof, or empty if the
+// version has no keys. Its size is thus linear in the number of keys.
+func (tree *MutableTree) MerkleConsistencyProof(v1, v2 int64) ([][]byte, error) {
+	if v1 >= v2 {
+		return nil, errors.Errorf("version %d must be before version %d", v1, v2)
+	}
+	changed, err := tree.IterateVersionChanges(v1, v2, func(key, v1val, v2val []byte) bool {
+		return v1val != nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		return nil, ErrNotAdditionsOnly
+	}
+
+	proof := make([][]byte, 0, 2)
+	for _, version := range []int64{v1, v2} {
+		t, err := tree.GetImmutable(version)
+		if err != nil {
+			return nil, err
+		}
+		bz, err := t.completeRangeProof()
+		if err != nil {
+			return nil, err
+		}
+		proof = append(proof, bz)
+	}
+	return proof, nil
+}
+
+// VerifyMerkleConsistencyProof verifies a proof returned by MerkleConsistencyProof against the
+// root hash of its first version, and returns the root hash of its second version, which the
+// caller must compare to the one it trusts. Returns an error wrapping ErrInvalidProof if the
+// proof is malformed, and ErrNotAdditionsOnly if the second version doesn't only add keys.
+func VerifyMerkleConsistencyProof(proof [][]byte, root1 []byte) (root2 []byte, err error) {
+	if len(proof) != 2 {
+		return nil, errors.Wrapf(ErrInvalidProof, "expected 2 range proofs, got %d", len(proof))
+	}
+	leaves1, hash1, err := decodeCompleteRangeProof(proof[0])
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(hash1, root1) {
+		return nil, errors.Wrapf(ErrInvalidRoot, "root hash %X doesn't match %X", hash1, root1)
+	}
+	leaves2, root2, err := decodeCompleteRangeProof(proof[1])
+	if err != nil {
+		return nil, err
+	}
+
+	valueHashes := make(map[string][]byte, len(leaves2))
+	for _, leaf := range leaves2 {
+		valueHashes[string(leaf.Key)] = leaf.ValueHash
+	}
+	for _, leaf := range leaves1 {
+		valueHash, ok := valueHashes[string(leaf.Key)]
+		if !ok || !bytes.Equal(valueHash, leaf.ValueHash) {
+			return nil, errors.Wrapf(ErrNotAdditionsOnly, "key %X", leaf.Key)
+		}
+	}
+	return root2, nil
+}
+
+// completeRangeProof returns the encoded range proof of all the keys of the tree, or nil if the
+// tree is empty.
+func (t *ImmutableTree) completeRangeProof() ([]byte, error) {
+	if t.root == nil {
+		return nil, nil
+	}
+	proof, _, _, err := t.getRangeProof(nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return proof.ToProto().Marshal()
+}
+
+// decodeCompleteRangeProof decodes a range proof returned by completeRangeProof, checks that it
+// covers all the keys of a tree, and returns its leaves and the root hash of the tree.
+func decodeCompleteRangeProof(bz []byte) ([]ProofLeafNode, []byte, error) {
+	if len(bz) == 0 {
+		return nil, sha256.New().Sum(nil), nil
+	}
+	var pbProof iavlproto.RangeProof
+	if err := pbProof.Unmarshal(bz); err != nil {
+		return nil, nil, errors.Wrapf(ErrInvalidProof, "%v", err)
+	}
+	proof, err := RangeProofFromProto(&pbProof)
+	if err != nil {
+		return nil, nil, err
+	}
+	root, err := proof.computeRootHash()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !proof.LeftPath.isLeftmost() || !proof.treeEnd {
+		return nil, nil, errors.Wrap(ErrInvalidProof, "proof doesn't cover all keys")
+	}
+	return proof.Leaves, root, nil
+}
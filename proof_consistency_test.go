@@ -0,0 +1,73 @@
+package iavl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerkleConsistencyProof(t *testing.T) {
+	tree := setupMutableTree(t, false)
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+	var roots [][]byte
+	for v := 0; v < 3; v++ {
+		for i := 0; i < 20; i++ {
+			_, err := tree.Set([]byte{byte(v*20 + i)}, []byte{byte(i)})
+			require.NoError(t, err)
+		}
+		hash, _, err := tree.SaveVersion()
+		require.NoError(t, err)
+		roots = append(roots, hash)
+	}
+	emptyRoot, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+	emptyHash, err := emptyRoot.Hash()
+	require.NoError(t, err)
+
+	proof, err := tree.MerkleConsistencyProof(2, 4)
+	require.NoError(t, err)
+	root, err := VerifyMerkleConsistencyProof(proof, roots[0])
+	require.NoError(t, err)
+	require.Equal(t, roots[2], root)
+
+	proof, err = tree.MerkleConsistencyProof(1, 2)
+	require.NoError(t, err)
+	root, err = VerifyMerkleConsistencyProof(proof, emptyHash)
+	require.NoError(t, err)
+	require.Equal(t, roots[0], root)
+
+	_, err = VerifyMerkleConsistencyProof(proof, roots[1])
+	require.ErrorIs(t, err, ErrInvalidRoot)
+	_, err = tree.MerkleConsistencyProof(3, 3)
+	require.Error(t, err)
+
+	// A proof of only some of the keys is rejected.
+	itree, err := tree.GetImmutable(3)
+	require.NoError(t, err)
+	_, _, partial, err := itree.GetRangeWithProof(nil, []byte{10}, 0)
+	require.NoError(t, err)
+	bz, err := partial.ToProto().Marshal()
+	require.NoError(t, err)
+	_, err = VerifyMerkleConsistencyProof([][]byte{bz, proof[1]}, roots[1])
+	require.ErrorIs(t, err, ErrInvalidProof)
+
+	// Changing a key is detected by both the prover and the verifier.
+	_, err = tree.Set([]byte{5}, []byte("changed"))
+	require.NoError(t, err)
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	_, err = tree.MerkleConsistencyProof(4, 5)
+	require.ErrorIs(t, err, ErrNotAdditionsOnly)
+
+	forged := make([][]byte, 0, 2)
+	for _, version := range []int64{4, 5} {
+		itree, err := tree.GetImmutable(version)
+		require.NoError(t, err)
+		bz, err := itree.completeRangeProof()
+		require.NoError(t, err)
+		forged = append(forged, bz)
+	}
+	_, err = VerifyMerkleConsistencyProof(forged, roots[2])
+	require.ErrorIs(t, err, ErrNotAdditionsOnly)
+}
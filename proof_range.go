@@ -183,6 +183,24 @@ func (proof *RangeProof) Verify(root []byte) error {
 	return err
 }
 
+// VerifyKeyValueAtRoot verifies that proof proves key has value in the tree with the given root
+// hash. It only depends on its arguments: the proof is verified from scratch, without using or
+// modifying any memoized state of proof.
+func VerifyKeyValueAtRoot(key, value, rootHash []byte, proof *RangeProof) error {
+	if proof == nil {
+		return errors.Wrap(ErrInvalidProof, "proof is nil")
+	}
+	fresh := &RangeProof{
+		LeftPath:   proof.LeftPath,
+		InnerNodes: proof.InnerNodes,
+		Leaves:     proof.Leaves,
+	}
+	if err := fresh.Verify(rootHash); err != nil {
+		return err
+	}
+	return fresh.VerifyItem(key, value)
+}
+
 func (proof *RangeProof) verify(root []byte) (err error) {
 	rootHash := proof.rootHash
 	if rootHash == nil {
@@ -387,7 +405,7 @@ func (t *ImmutableTree) getRangeProof(keyStart, keyEnd []byte, limit int) (proof
 		return nil, nil, nil, nil
 	}
 
-	_, _, err = t.root.hashWithCount() // Ensure that all hashes are calculated.
+	_, _, err = t.root.hashWithCount(t.hashObserver()) // Ensure that all hashes are calculated.
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -539,6 +557,35 @@ func (t *ImmutableTree) GetWithProof(key []byte) (value []byte, proof *RangeProo
 	return nil, proof, nil
 }
 
+// IndexedGet gets the key and value at the given 0-based rank in the tree, along with a proof
+// of its existence, in a single pass. Returns ErrRankOutOfBounds unless 0 <= rank < Size().
+func (t *ImmutableTree) IndexedGet(rank int64) (key, value []byte, proof *RangeProof, err error) {
+	if rank < 0 || rank >= t.Size() {
+		return nil, nil, nil, errors.Wrapf(ErrRankOutOfBounds, "%d with size %d", rank, t.Size())
+	}
+
+	_, _, err = t.root.hashWithCount(t.hashObserver()) // Ensure that all hashes are calculated.
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	path := PathToLeaf{}
+	leaf, err := t.root.pathToLeafByIndex(t, rank, &path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	valueHash := sha256.Sum256(leaf.value)
+	proof = &RangeProof{
+		LeftPath: path,
+		Leaves: []ProofLeafNode{{
+			Key:       leaf.key,
+			ValueHash: valueHash[:],
+			Version:   leaf.version,
+		}},
+	}
+	return leaf.key, leaf.value, proof, nil
+}
+
 // GetRangeWithProof gets key/value pairs within the specified range and limit.
 func (t *ImmutableTree) GetRangeWithProof(startKey []byte, endKey []byte, limit int) (keys, values [][]byte, proof *RangeProof, err error) {
 	proof, keys, values, err = t.getRangeProof(startKey, endKey, limit)
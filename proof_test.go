@@ -50,6 +50,151 @@ func TestTreeGetWithProof(t *testing.T) {
 	require.NoError(err, "%+v", err)
 }
 
+func TestTreeIndexedGet(t *testing.T) {
+	tree, err := getTestTree(0)
+	require.NoError(t, err)
+	keys := [][]byte{}
+	for i := 0; i < 50; i++ {
+		key := []byte(iavlrand.RandStr(8))
+		tree.Set(key, []byte(iavlrand.RandStr(8)))
+		keys = append(keys, key)
+	}
+	sortByteSlices(keys)
+	root, err := tree.WorkingHash()
+	require.NoError(t, err)
+
+	for rank, expected := range keys {
+		key, value, proof, err := tree.IndexedGet(int64(rank))
+		require.NoError(t, err)
+		require.Equal(t, expected, key)
+		require.NoError(t, proof.Verify(root))
+		require.NoError(t, proof.VerifyItem(key, value))
+	}
+
+	_, _, _, err = tree.IndexedGet(-1)
+	require.ErrorIs(t, err, ErrRankOutOfBounds)
+	_, _, _, err = tree.IndexedGet(int64(len(keys)))
+	require.ErrorIs(t, err, ErrRankOutOfBounds)
+}
+
+func TestTreeHashesForRange(t *testing.T) {
+	tree, err := getTestTree(0)
+	require.NoError(t, err)
+	for i := byte(0); i < 50; i++ {
+		_, err := tree.Set([]byte{i * 2}, []byte(iavlrand.RandStr(8)))
+		require.NoError(t, err)
+	}
+
+	start, end := []byte{9}, []byte{40}
+	keys, _, proof, err := tree.GetRangeWithProof(start, end, 0)
+	require.NoError(t, err)
+	expected := [][]byte{}
+	for _, leaf := range proof.Leaves {
+		if bytes.Compare(leaf.Key, start) >= 0 && bytes.Compare(leaf.Key, end) < 0 {
+			hash, err := leaf.Hash()
+			require.NoError(t, err)
+			expected = append(expected, hash)
+		}
+	}
+	require.Len(t, expected, len(keys))
+
+	hashes, err := tree.HashesForRange(start, end)
+	require.NoError(t, err)
+	require.Equal(t, expected, hashes)
+
+	hashes, err = tree.HashesForRange([]byte{200}, nil)
+	require.NoError(t, err)
+	require.Empty(t, hashes)
+}
+
+func TestTreeIterateAndCollectHashes(t *testing.T) {
+	tree, err := getTestTree(0)
+	require.NoError(t, err)
+	for i := byte(0); i < 50; i++ {
+		_, err := tree.Set([]byte{i}, []byte{i % 3})
+		require.NoError(t, err)
+	}
+
+	all, err := tree.HashesForRange(nil, nil)
+	require.NoError(t, err)
+	require.Len(t, all, 50)
+
+	visited := 0
+	hashes, err := tree.IterateAndCollectHashes(func(key, value []byte) bool {
+		require.Equal(t, []byte{byte(visited)}, key)
+		visited++
+		return value[0] == 0
+	})
+	require.NoError(t, err)
+	require.Equal(t, 50, visited)
+	expected := [][]byte{}
+	for i := 0; i < 50; i += 3 {
+		expected = append(expected, all[i])
+	}
+	require.Equal(t, expected, hashes)
+}
+
+func TestTreeIterateWithLeafHash(t *testing.T) {
+	tree, err := getTestTree(0)
+	require.NoError(t, err)
+	for i := byte(0); i < 50; i++ {
+		_, err := tree.Set([]byte{i}, []byte{i})
+		require.NoError(t, err)
+	}
+
+	expected, err := tree.HashesForRange([]byte{10}, []byte{20})
+	require.NoError(t, err)
+	hashes := [][]byte{}
+	stopped, err := tree.IterateWithLeafHash([]byte{10}, []byte{20}, func(key, value, hash []byte) bool {
+		require.Equal(t, []byte{byte(10 + len(hashes))}, key)
+		require.Equal(t, key, value)
+		hashes = append(hashes, hash)
+		return false
+	})
+	require.NoError(t, err)
+	require.False(t, stopped)
+	require.Equal(t, expected, hashes)
+
+	// Hashes of unsaved leaves are computed as needed.
+	_, err = tree.Set([]byte{15}, []byte("new"))
+	require.NoError(t, err)
+	stopped, err = tree.IterateWithLeafHash([]byte{15}, nil, func(key, value, hash []byte) bool {
+		leaf := NewNode(key, value, tree.version+1)
+		expectedHash, err := leaf._hash()
+		require.NoError(t, err)
+		require.Equal(t, expectedHash, hash)
+		return true
+	})
+	require.NoError(t, err)
+	require.True(t, stopped)
+}
+
+func TestVerifyKeyValueAtRoot(t *testing.T) {
+	tree, err := getTestTree(0)
+	require.NoError(t, err)
+	for _, ikey := range []byte{0x11, 0x32, 0x50, 0x72, 0x99} {
+		tree.Set([]byte{ikey}, []byte{ikey, ikey})
+	}
+	root, err := tree.WorkingHash()
+	require.NoError(t, err)
+
+	key := []byte{0x50}
+	value, proof, err := tree.GetWithProof(key)
+	require.NoError(t, err)
+	require.NoError(t, VerifyKeyValueAtRoot(key, value, root, proof))
+
+	require.ErrorIs(t, VerifyKeyValueAtRoot(key, []byte("wrong"), root, proof), ErrInvalidProof)
+	require.ErrorIs(t, VerifyKeyValueAtRoot([]byte{0x51}, value, root, proof), ErrInvalidProof)
+	require.ErrorIs(t, VerifyKeyValueAtRoot(key, value, []byte("wrong root"), proof), ErrInvalidRoot)
+	require.ErrorIs(t, VerifyKeyValueAtRoot(key, value, root, nil), ErrInvalidProof)
+
+	// Memoized state of the proof is neither used nor modified.
+	require.False(t, proof.rootVerified)
+	require.NoError(t, proof.Verify(root))
+	proof.Leaves[0].ValueHash = []byte("tampered")
+	require.Error(t, VerifyKeyValueAtRoot(key, value, root, proof))
+}
+
 func TestTreeKeyExistsProof(t *testing.T) {
 	tree, err := getTestTree(0)
 	require.NoError(t, err)
@@ -0,0 +1,104 @@
+package iavl
+
+import (
+	"github.com/pkg/errors"
+)
+
+// RangeTraversal builds a range query over an ImmutableTree. It is created by
+// ImmutableTree.TraverseRange(), configured by chaining its methods, and run with Execute().
+//
+// By default, all keys in the range are returned in ascending order, without versions or proof.
+type RangeTraversal struct {
+	tree       *ImmutableTree
+	start, end []byte
+	limit      int
+	descending bool
+	proof      bool
+	versions   bool
+}
+
+// RangeResult contains the key/value pairs found by a RangeTraversal, in traversal order.
+// Versions is only set when requested with RangeTraversal.WithVersions(), and Proof only when
+// requested with RangeTraversal.WithProof().
+type RangeResult struct {
+	Keys     [][]byte
+	Values   [][]byte
+	Versions []int64
+	Proof    *RangeProof
+}
+
+// TraverseRange returns a RangeTraversal over the keys between start (inclusive) and end
+// (exclusive). If either are nil, then the range is open on that side.
+func (t *ImmutableTree) TraverseRange(start, end []byte) *RangeTraversal {
+	return &RangeTraversal{
+		tree:  t,
+		start: start,
+		end:   end,
+	}
+}
+
+// Limit sets the maximum number of key/value pairs to return. 0 means no limit.
+func (rt *RangeTraversal) Limit(n int) *RangeTraversal {
+	rt.limit = n
+	return rt
+}
+
+// Descending makes the traversal return keys in descending order.
+func (rt *RangeTraversal) Descending() *RangeTraversal {
+	rt.descending = true
+	return rt
+}
+
+// WithProof makes the traversal return a RangeProof covering the returned key/value pairs.
+func (rt *RangeTraversal) WithProof() *RangeTraversal {
+	rt.proof = true
+	return rt
+}
+
+// WithVersions makes the traversal return the version of each returned key/value pair.
+func (rt *RangeTraversal) WithVersions() *RangeTraversal {
+	rt.versions = true
+	return rt
+}
+
+// Execute runs the traversal. The returned keys and values must not be modified, since they
+// may point to data stored within IAVL.
+func (rt *RangeTraversal) Execute() (RangeResult, error) {
+	if rt.limit < 0 {
+		return RangeResult{}, errors.New("limit must be greater or equal to 0 -- 0 means no limit")
+	}
+
+	result := RangeResult{}
+	_, err := rt.tree.traverseLeaves(rt.start, rt.end, !rt.descending, false, func(node *Node) bool {
+		result.Keys = append(result.Keys, node.key)
+		result.Values = append(result.Values, node.value)
+		if rt.versions {
+			result.Versions = append(result.Versions, node.version)
+		}
+		return rt.limit > 0 && len(result.Keys) >= rt.limit
+	})
+	if err != nil {
+		return RangeResult{}, err
+	}
+
+	if rt.proof {
+		// When the limit was reached, the proof only needs to cover the range up to the last
+		// returned key.
+		start, end := rt.start, rt.end
+		if rt.limit > 0 && len(result.Keys) >= rt.limit {
+			last := result.Keys[len(result.Keys)-1]
+			if rt.descending {
+				start = last
+			} else {
+				// The smallest key greater than last.
+				end = append(cp(last), 0x00)
+			}
+		}
+		result.Proof, _, _, err = rt.tree.getRangeProof(start, end, 0)
+		if err != nil {
+			return RangeResult{}, err
+		}
+	}
+
+	return result, nil
+}
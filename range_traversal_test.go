@@ -0,0 +1,53 @@
+package iavl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestImmutableTree_TraverseRange(t *testing.T) {
+	tree, err := getTestTree(0)
+	require.NoError(t, err)
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		_, err = tree.Set([]byte(k), []byte("v"+k))
+		require.NoError(t, err)
+	}
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	_, err = tree.Set([]byte("c"), []byte("vc2"))
+	require.NoError(t, err)
+	rootHash, version, err := tree.SaveVersion()
+	require.NoError(t, err)
+
+	itree, err := tree.GetImmutable(version)
+	require.NoError(t, err)
+
+	result, err := itree.TraverseRange([]byte("b"), []byte("e")).Execute()
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("b"), []byte("c"), []byte("d")}, result.Keys)
+	require.Equal(t, [][]byte{[]byte("vb"), []byte("vc2"), []byte("vd")}, result.Values)
+	require.Nil(t, result.Versions)
+	require.Nil(t, result.Proof)
+
+	result, err = itree.TraverseRange(nil, nil).Descending().Limit(3).WithVersions().WithProof().Execute()
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("e"), []byte("d"), []byte("c")}, result.Keys)
+	require.Equal(t, []int64{1, 1, 2}, result.Versions)
+	require.NotNil(t, result.Proof)
+	require.NoError(t, result.Proof.Verify(rootHash))
+	for i, key := range result.Keys {
+		require.NoError(t, result.Proof.VerifyItem(key, result.Values[i]))
+	}
+
+	result, err = itree.TraverseRange([]byte("a"), nil).Limit(2).WithProof().Execute()
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("a"), []byte("b")}, result.Keys)
+	require.NoError(t, result.Proof.Verify(rootHash))
+	for i, key := range result.Keys {
+		require.NoError(t, result.Proof.VerifyItem(key, result.Values[i]))
+	}
+
+	_, err = itree.TraverseRange(nil, nil).Limit(-1).Execute()
+	require.Error(t, err)
+}
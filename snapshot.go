@@ -0,0 +1,244 @@
+package iavl
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"os"
+
+	dbm "github.com/cosmos/cosmos-db"
+	"github.com/pkg/errors"
+
+	"github.com/cosmos/iavl/internal/encoding"
+)
+
+// snapshotMagic identifies snapshot files written by StoreSnapshot.
+var snapshotMagic = []byte("IAVLSNAP")
+
+// snapshotFormat is the version of the snapshot file format.
+const snapshotFormat = 1
+
+// ErrInvalidSnapshot is returned when a snapshot file is malformed or corrupted.
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+// StoreSnapshot writes all nodes of the tree to a single file at path, which can be restored
+// with RestoreSnapshot(). The file has the following format:
+//
+//	magic | format | version | root hash | node count | nodes... | sha256 checksum
+//
+// where nodes are written depth-first post-order (LRN), as in Export(), each as its height,
+// version, key and, for leaves, value. The checksum covers all preceding bytes.
+func (t *ImmutableTree) StoreSnapshot(path string) (err error) {
+	version := t.version
+	var rootHash []byte
+	var nodeCount uint64
+	if t.root != nil {
+		if rootHash, err = t.Hash(); err != nil {
+			return err
+		}
+		if t.root.version > version {
+			// Unsaved nodes of a working tree have the version they will be saved as.
+			version = t.root.version
+		}
+		nodeCount = uint64(2*t.root.size - 1)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	checksum := sha256.New()
+	buf := bufio.NewWriter(file)
+	w := io.MultiWriter(buf, checksum)
+
+	if _, err := w.Write(snapshotMagic); err != nil {
+		return err
+	}
+	if err := encoding.EncodeUvarint(w, snapshotFormat); err != nil {
+		return errors.Wrap(err, "writing format")
+	}
+	if err := encoding.EncodeVarint(w, version); err != nil {
+		return errors.Wrap(err, "writing version")
+	}
+	if err := encoding.EncodeBytes(w, rootHash); err != nil {
+		return errors.Wrap(err, "writing root hash")
+	}
+	if err := encoding.EncodeUvarint(w, nodeCount); err != nil {
+		return errors.Wrap(err, "writing node count")
+	}
+	if t.root != nil {
+		if err := t.writeSnapshotNode(w, t.root); err != nil {
+			return err
+		}
+	}
+	if _, err := buf.Write(checksum.Sum(nil)); err != nil {
+		return errors.Wrap(err, "writing checksum")
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// writeSnapshotNode writes node and its descendants to w, in post-order.
+func (t *ImmutableTree) writeSnapshotNode(w io.Writer, node *Node) error {
+	if !node.isLeaf() {
+		leftNode, err := node.getLeftNode(t)
+		if err != nil {
+			return err
+		}
+		if err := t.writeSnapshotNode(w, leftNode); err != nil {
+			return err
+		}
+		rightNode, err := node.getRightNode(t)
+		if err != nil {
+			return err
+		}
+		if err := t.writeSnapshotNode(w, rightNode); err != nil {
+			return err
+		}
+	}
+
+	if err := encoding.EncodeVarint(w, int64(node.subtreeHeight)); err != nil {
+		return errors.Wrap(err, "writing height")
+	}
+	if err := encoding.EncodeVarint(w, node.version); err != nil {
+		return errors.Wrap(err, "writing version")
+	}
+	if err := encoding.EncodeBytes(w, node.key); err != nil {
+		return errors.Wrap(err, "writing key")
+	}
+	if node.isLeaf() {
+		if err := encoding.EncodeBytes(w, node.value); err != nil {
+			return errors.Wrap(err, "writing value")
+		}
+	}
+	return nil
+}
+
+// RestoreSnapshot reads a snapshot written by StoreSnapshot() into a new tree backed by a
+// MemDB, at the version the snapshot was taken. Returns ErrInvalidSnapshot if the file is
+// corrupted, or if the restored root hash does not match the snapshotted one.
+func RestoreSnapshot(path string) (*MutableTree, error) {
+	bz, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(bz) < len(snapshotMagic)+sha256.Size || !bytes.Equal(bz[:len(snapshotMagic)], snapshotMagic) {
+		return nil, errors.Wrap(ErrInvalidSnapshot, "not a snapshot file")
+	}
+	checksum := sha256.Sum256(bz[:len(bz)-sha256.Size])
+	if !bytes.Equal(checksum[:], bz[len(bz)-sha256.Size:]) {
+		return nil, errors.Wrap(ErrInvalidSnapshot, "checksum mismatch")
+	}
+	r := &snapshotReader{bz: bz[len(snapshotMagic) : len(bz)-sha256.Size]}
+
+	format := r.uvarint()
+	version := r.varint()
+	rootHash := r.bytes()
+	nodeCount := r.uvarint()
+	if r.err != nil {
+		return nil, r.err
+	}
+	if format != snapshotFormat {
+		return nil, errors.Wrapf(ErrInvalidSnapshot, "unsupported format %d", format)
+	}
+
+	tree, err := NewMutableTree(dbm.NewMemDB(), 0, false)
+	if err != nil {
+		return nil, err
+	}
+	if version == 0 && nodeCount == 0 {
+		return tree, nil
+	}
+
+	importer, err := tree.Import(version)
+	if err != nil {
+		return nil, err
+	}
+	defer importer.Close()
+
+	for i := uint64(0); i < nodeCount; i++ {
+		node := &ExportNode{
+			Height:  int8(r.varint()),
+			Version: r.varint(),
+			Key:     r.bytes(),
+		}
+		if node.Height == 0 {
+			node.Value = r.bytes()
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		if err := importer.Add(node); err != nil {
+			return nil, errors.Wrapf(ErrInvalidSnapshot, "importing node: %v", err)
+		}
+	}
+	if len(r.bz) > 0 {
+		return nil, errors.Wrapf(ErrInvalidSnapshot, "found %d trailing bytes", len(r.bz))
+	}
+	if err := importer.Commit(); err != nil {
+		return nil, err
+	}
+
+	hash, err := tree.Hash()
+	if err != nil {
+		return nil, err
+	}
+	if len(rootHash) > 0 && !bytes.Equal(hash, rootHash) {
+		return nil, errors.Wrapf(ErrInvalidSnapshot, "restored root hash %X does not match snapshot root hash %X", hash, rootHash)
+	}
+	return tree, nil
+}
+
+// snapshotReader decodes snapshot fields, keeping the first error encountered.
+type snapshotReader struct {
+	bz  []byte
+	err error
+}
+
+func (r *snapshotReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	u, n, err := encoding.DecodeUvarint(r.bz)
+	if err != nil {
+		r.err = errors.Wrapf(ErrInvalidSnapshot, "decoding uvarint: %v", err)
+		return 0
+	}
+	r.bz = r.bz[n:]
+	return u
+}
+
+func (r *snapshotReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	i, n, err := encoding.DecodeVarint(r.bz)
+	if err != nil {
+		r.err = errors.Wrapf(ErrInvalidSnapshot, "decoding varint: %v", err)
+		return 0
+	}
+	r.bz = r.bz[n:]
+	return i
+}
+
+func (r *snapshotReader) bytes() []byte {
+	if r.err != nil {
+		return nil
+	}
+	bz, n, err := encoding.DecodeBytes(r.bz)
+	if err != nil {
+		r.err = errors.Wrapf(ErrInvalidSnapshot, "decoding bytes: %v", err)
+		return nil
+	}
+	r.bz = r.bz[n:]
+	return bz
+}
@@ -0,0 +1,67 @@
+package iavl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreRestoreSnapshot(t *testing.T) {
+	tree, mirror := getRandomizedTreeAndMirror(t)
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+
+	// Snapshot the working tree, including unsaved changes.
+	_, err = tree.Set([]byte("unsaved"), []byte{})
+	require.NoError(t, err)
+	mirror["unsaved"] = ""
+	workingHash, err := tree.WorkingHash()
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "snapshot")
+	require.NoError(t, tree.StoreSnapshot(path))
+
+	restored, err := RestoreSnapshot(path)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, restored.Version())
+	hash, err := restored.Hash()
+	require.NoError(t, err)
+	require.Equal(t, workingHash, hash)
+	for key, value := range mirror {
+		actual, err := restored.Get([]byte(key))
+		require.NoError(t, err)
+		require.Equal(t, value, string(actual))
+	}
+
+	// Snapshots are deterministic.
+	path2 := filepath.Join(t.TempDir(), "snapshot2")
+	require.NoError(t, restored.StoreSnapshot(path2))
+	bz, err := os.ReadFile(path)
+	require.NoError(t, err)
+	bz2, err := os.ReadFile(path2)
+	require.NoError(t, err)
+	require.Equal(t, bz, bz2)
+
+	// Corruption is detected by the checksum.
+	bz[len(bz)/2] ^= 0xff
+	require.NoError(t, os.WriteFile(path, bz, 0o600))
+	_, err = RestoreSnapshot(path)
+	require.ErrorIs(t, err, ErrInvalidSnapshot)
+
+	require.NoError(t, os.WriteFile(path, []byte("not a snapshot"), 0o600))
+	_, err = RestoreSnapshot(path)
+	require.ErrorIs(t, err, ErrInvalidSnapshot)
+}
+
+func TestStoreRestoreSnapshot_Empty(t *testing.T) {
+	tree := setupMutableTree(t, false)
+	path := filepath.Join(t.TempDir(), "snapshot")
+	require.NoError(t, tree.StoreSnapshot(path))
+
+	restored, err := RestoreSnapshot(path)
+	require.NoError(t, err)
+	require.True(t, restored.IsEmpty())
+	require.EqualValues(t, 0, restored.Version())
+}
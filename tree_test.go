@@ -9,7 +9,9 @@ import (
 	"math/rand"
 	"os"
 	"runtime"
+	"sort"
 	"strconv"
+	"sync"
 	"testing"
 
 	db "github.com/cosmos/cosmos-db"
@@ -1883,6 +1885,653 @@ func TestGetWithIndex_ImmutableTree(t *testing.T) {
 	}
 }
 
+func TestIterateFromIndex_ImmutableTree(t *testing.T) {
+	tree, mirror := getRandomizedTreeAndMirror(t)
+	mirrorKeys := getSortedMirrorKeys(mirror)
+
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+
+	immutableTree, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+
+	for _, startIndex := range []int{0, 1, len(mirrorKeys) / 2, len(mirrorKeys) - 1} {
+		keys := []string{}
+		stopped, err := immutableTree.IterateFromIndex(int64(startIndex), func(key, value []byte) bool {
+			require.Equal(t, mirror[string(key)], string(value))
+			keys = append(keys, string(key))
+			return false
+		})
+		require.NoError(t, err)
+		require.False(t, stopped)
+		require.Equal(t, mirrorKeys[startIndex:], keys)
+	}
+
+	count := 0
+	stopped, err := immutableTree.IterateFromIndex(3, func(key, value []byte) bool {
+		count++
+		return count == 2
+	})
+	require.NoError(t, err)
+	require.True(t, stopped)
+
+	stopped, err = immutableTree.IterateFromIndex(int64(len(mirrorKeys)), func(key, value []byte) bool {
+		t.Fatal("callback must not be called past the last index")
+		return false
+	})
+	require.NoError(t, err)
+	require.False(t, stopped)
+
+	_, err = immutableTree.IterateFromIndex(-1, func(key, value []byte) bool { return false })
+	require.Error(t, err)
+}
+
+func TestKeyRangeByRank_ImmutableTree(t *testing.T) {
+	tree, mirror := getRandomizedTreeAndMirror(t)
+	mirrorKeys := getSortedMirrorKeys(mirror)
+	size := int64(len(mirrorKeys))
+
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+
+	immutableTree, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+
+	for _, r := range [][2]int64{{0, size}, {0, 1}, {5, 17}, {size - 1, size}, {size, size}} {
+		keys, values, err := immutableTree.KeyRangeByRank(r[0], r[1])
+		require.NoError(t, err)
+		require.Len(t, keys, int(r[1]-r[0]))
+		for i, key := range keys {
+			require.Equal(t, mirrorKeys[r[0]+int64(i)], string(key))
+			require.Equal(t, mirror[string(key)], string(values[i]))
+		}
+	}
+
+	for _, r := range [][2]int64{{-1, 2}, {3, 2}, {0, size + 1}} {
+		_, _, err := immutableTree.KeyRangeByRank(r[0], r[1])
+		require.ErrorIs(t, err, ErrRankOutOfBounds)
+	}
+}
+
+func TestExclusiveAndInclusiveRange_ImmutableTree(t *testing.T) {
+	tree, mirror := getRandomizedTreeAndMirror(t)
+	mirrorKeys := getSortedMirrorKeys(mirror)
+	size := len(mirrorKeys)
+
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+
+	immutableTree, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+
+	requireKeys := func(expected []string, keys, values [][]byte) {
+		require.Len(t, keys, len(expected))
+		require.Len(t, values, len(expected))
+		for i, key := range keys {
+			require.Equal(t, expected[i], string(key))
+			require.Equal(t, mirror[string(key)], string(values[i]))
+		}
+	}
+
+	start, end := []byte(mirrorKeys[3]), []byte(mirrorKeys[size-4])
+	keys, values, err := immutableTree.ExclusiveRange(start, end)
+	require.NoError(t, err)
+	requireKeys(mirrorKeys[4:size-4], keys, values)
+	keys, values, err = immutableTree.InclusiveRange(start, end)
+	require.NoError(t, err)
+	requireKeys(mirrorKeys[3:size-3], keys, values)
+
+	keys, values, err = immutableTree.ExclusiveRange(nil, nil)
+	require.NoError(t, err)
+	requireKeys(mirrorKeys, keys, values)
+	keys, values, err = immutableTree.InclusiveRange(nil, end)
+	require.NoError(t, err)
+	requireKeys(mirrorKeys[:size-3], keys, values)
+
+	keys, _, err = immutableTree.ExclusiveRange(start, start)
+	require.NoError(t, err)
+	require.Empty(t, keys)
+	keys, _, err = immutableTree.InclusiveRange(start, start)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+}
+
+func TestBinarySearch_ImmutableTree(t *testing.T) {
+	tree, mirror := getRandomizedTreeAndMirror(t)
+	mirrorKeys := getSortedMirrorKeys(mirror)
+
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+
+	immutableTree, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+
+	probes := append([]string{"", "\xff\xff"}, mirrorKeys...)
+	for _, key := range mirrorKeys {
+		probes = append(probes, key+"\x00", key[:len(key)-1])
+	}
+	for _, probe := range probes {
+		rank := sort.SearchStrings(mirrorKeys, probe)
+		exactMatch := rank < len(mirrorKeys) && mirrorKeys[rank] == probe
+		var leftKey, rightKey []byte
+		if rank > 0 {
+			leftKey = []byte(mirrorKeys[rank-1])
+		}
+		next := rank
+		if exactMatch {
+			next++
+		}
+		if next < len(mirrorKeys) {
+			rightKey = []byte(mirrorKeys[next])
+		}
+
+		actualMatch, actualRank, actualLeft, actualRight, err := immutableTree.BinarySearch([]byte(probe))
+		require.NoError(t, err)
+		require.Equal(t, exactMatch, actualMatch, "probe %q", probe)
+		require.EqualValues(t, rank, actualRank, "probe %q", probe)
+		require.Equal(t, leftKey, actualLeft, "probe %q", probe)
+		require.Equal(t, rightKey, actualRight, "probe %q", probe)
+	}
+
+	empty, err := getTestTree(0)
+	require.NoError(t, err)
+	exactMatch, rank, leftKey, rightKey, err := empty.BinarySearch([]byte("a"))
+	require.NoError(t, err)
+	require.False(t, exactMatch)
+	require.Zero(t, rank)
+	require.Nil(t, leftKey)
+	require.Nil(t, rightKey)
+}
+
+func TestGetNearest_ImmutableTree(t *testing.T) {
+	tree, err := getTestTree(0)
+	require.NoError(t, err)
+	for _, key := range []string{"\x10", "\x20", "\x30\x00", "\x40"} {
+		_, err := tree.Set([]byte(key), []byte("v"+key))
+		require.NoError(t, err)
+	}
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+
+	immutableTree, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+
+	for probe, expected := range map[string]string{
+		"\x00":     "\x10", // before the first key
+		"\x10":     "\x10", // exact match
+		"\x17":     "\x10",
+		"\x18":     "\x10", // tie goes to the predecessor
+		"\x19":     "\x20",
+		"\x28\x01": "\x30\x00",
+		"\x38\x00": "\x30\x00", // tie
+		"\xff":     "\x40",     // after the last key
+	} {
+		key, value, err := immutableTree.GetNearest([]byte(probe))
+		require.NoError(t, err)
+		require.Equal(t, expected, string(key), "probe %X", probe)
+		require.Equal(t, "v"+expected, string(value), "probe %X", probe)
+	}
+
+	empty, err := getTestTree(0)
+	require.NoError(t, err)
+	_, _, err = empty.GetNearest([]byte("a"))
+	require.ErrorIs(t, err, ErrEmptyTree)
+}
+
+func TestAbsoluteIndex_ImmutableTree(t *testing.T) {
+	tree, mirror := getRandomizedTreeAndMirror(t)
+	mirrorKeys := getSortedMirrorKeys(mirror)
+
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+
+	immutableTree, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+
+	for i, key := range mirrorKeys {
+		rank, err := immutableTree.AbsoluteIndex([]byte(key))
+		require.NoError(t, err)
+		require.EqualValues(t, i, rank)
+
+		_, err = immutableTree.AbsoluteIndex([]byte(key + "\x00"))
+		require.ErrorIs(t, err, ErrKeyNotFound)
+	}
+
+	empty, err := getTestTree(0)
+	require.NoError(t, err)
+	_, err = empty.AbsoluteIndex([]byte("a"))
+	require.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestFirstKeyLastKey_ImmutableTree(t *testing.T) {
+	tree, mirror := getRandomizedTreeAndMirror(t)
+	mirrorKeys := getSortedMirrorKeys(mirror)
+
+	// Working tree.
+	first, err := tree.FirstKey()
+	require.NoError(t, err)
+	require.Equal(t, mirrorKeys[0], string(first))
+	last, err := tree.LastKey()
+	require.NoError(t, err)
+	require.Equal(t, mirrorKeys[len(mirrorKeys)-1], string(last))
+
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+
+	immutableTree, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+	first, err = immutableTree.FirstKey()
+	require.NoError(t, err)
+	require.Equal(t, mirrorKeys[0], string(first))
+	last, err = immutableTree.LastKey()
+	require.NoError(t, err)
+	require.Equal(t, mirrorKeys[len(mirrorKeys)-1], string(last))
+
+	empty, err := getTestTree(0)
+	require.NoError(t, err)
+	_, err = empty.FirstKey()
+	require.ErrorIs(t, err, ErrEmptyTree)
+	_, err = empty.LastKey()
+	require.ErrorIs(t, err, ErrEmptyTree)
+}
+
+func TestKeyRange_ImmutableTree(t *testing.T) {
+	tree, mirror := getRandomizedTreeAndMirror(t)
+	mirrorKeys := getSortedMirrorKeys(mirror)
+
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+
+	immutableTree, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+	minKey, maxKey, err := immutableTree.KeyRange()
+	require.NoError(t, err)
+	require.Equal(t, mirrorKeys[0], string(minKey))
+	require.Equal(t, mirrorKeys[len(mirrorKeys)-1], string(maxKey))
+
+	single, err := getTestTree(0)
+	require.NoError(t, err)
+	_, err = single.Set([]byte("a"), []byte("1"))
+	require.NoError(t, err)
+	minKey, maxKey, err = single.KeyRange()
+	require.NoError(t, err)
+	require.Equal(t, []byte("a"), minKey)
+	require.Equal(t, []byte("a"), maxKey)
+
+	empty, err := getTestTree(0)
+	require.NoError(t, err)
+	_, _, err = empty.KeyRange()
+	require.ErrorIs(t, err, ErrEmptyTree)
+}
+
+func TestPartitionByCount_ImmutableTree(t *testing.T) {
+	tree, mirror := getRandomizedTreeAndMirror(t)
+	mirrorKeys := getSortedMirrorKeys(mirror)
+
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+
+	immutableTree, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+
+	for _, n := range []int{1, 2, 3, 7, len(mirrorKeys)} {
+		partitions, err := immutableTree.PartitionByCount(n)
+		require.NoError(t, err)
+		require.Len(t, partitions, n-1)
+
+		prev := 0
+		for i := 0; i < n; i++ {
+			next := len(mirrorKeys)
+			if i < n-1 {
+				next = sort.SearchStrings(mirrorKeys, string(partitions[i]))
+				require.Equal(t, mirrorKeys[next], string(partitions[i]))
+			}
+			count := next - prev
+			require.GreaterOrEqual(t, count, len(mirrorKeys)/n, "n=%d partition %d", n, i)
+			require.LessOrEqual(t, count, len(mirrorKeys)/n+1, "n=%d partition %d", n, i)
+			prev = next
+		}
+	}
+
+	_, err = immutableTree.PartitionByCount(0)
+	require.Error(t, err)
+	_, err = immutableTree.PartitionByCount(len(mirrorKeys) + 1)
+	require.Error(t, err)
+
+	empty, err := getTestTree(0)
+	require.NoError(t, err)
+	partitions, err := empty.PartitionByCount(1)
+	require.NoError(t, err)
+	require.Empty(t, partitions)
+}
+
+func TestIterateParallel_ImmutableTree(t *testing.T) {
+	tree, mirror := getRandomizedTreeAndMirror(t)
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+
+	immutableTree, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+
+	for _, workers := range []int{1, 4, len(mirror) + 10} {
+		var mtx sync.Mutex
+		seen := map[string]string{}
+		calls := 0
+		err := immutableTree.IterateParallel(workers, func(key, value []byte) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			seen[string(key)] = string(value)
+			calls++
+		})
+		require.NoError(t, err)
+		require.Equal(t, mirror, seen, "workers %d", workers)
+		require.Equal(t, len(mirror), calls, "workers %d", workers)
+	}
+
+	require.Error(t, immutableTree.IterateParallel(0, func(key, value []byte) {}))
+}
+
+func TestKeyValueSizes_ImmutableTree(t *testing.T) {
+	tree, err := getTestTree(0)
+	require.NoError(t, err)
+	for key, size := range map[string]int{"a": 0, "b": 1, "c": 2, "dd": 3, "ee": 4, "fff": 5, "ggg": 1000} {
+		_, err := tree.Set([]byte(key), make([]byte, size))
+		require.NoError(t, err)
+	}
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+
+	immutableTree, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+	keySizes, valueSizes, err := immutableTree.KeyValueSizes()
+	require.NoError(t, err)
+	require.Equal(t, map[int]int{1: 3, 2: 2, 3: 2}, keySizes)
+	require.Equal(t, map[int]int{0: 1, 1: 1, 2: 1, 4: 2, 8: 1, 1024: 1}, valueSizes)
+
+	empty, err := getTestTree(0)
+	require.NoError(t, err)
+	keySizes, valueSizes, err = empty.KeyValueSizes()
+	require.NoError(t, err)
+	require.Empty(t, keySizes)
+	require.Empty(t, valueSizes)
+}
+
+func TestSubTreeIterator(t *testing.T) {
+	tree, _ := getRandomizedTreeAndMirror(t)
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+	// Unsaved changes mix unsaved and persisted nodes in the working tree.
+	_, err = tree.Set([]byte("unsaved"), []byte{})
+	require.NoError(t, err)
+	_, err = tree.WorkingHash()
+	require.NoError(t, err)
+
+	var leaves func(node *Node) []string
+	leaves = func(node *Node) []string {
+		if node.isLeaf() {
+			return []string{string(node.key)}
+		}
+		left, err := node.getLeftNode(tree.ImmutableTree)
+		require.NoError(t, err)
+		right, err := node.getRightNode(tree.ImmutableTree)
+		require.NoError(t, err)
+		return append(leaves(left), leaves(right)...)
+	}
+
+	// Check every node along the leftmost and rightmost paths, and a node below both.
+	nodes := []*Node{}
+	for _, leftmost := range []bool{true, false} {
+		node := tree.root
+		for !node.isLeaf() {
+			nodes = append(nodes, node)
+			if leftmost {
+				node, err = node.getLeftNode(tree.ImmutableTree)
+			} else {
+				node, err = node.getRightNode(tree.ImmutableTree)
+			}
+			require.NoError(t, err)
+		}
+		nodes = append(nodes, node)
+	}
+	inner, err := tree.root.getLeftNode(tree.ImmutableTree)
+	require.NoError(t, err)
+	inner, err = inner.getRightNode(tree.ImmutableTree)
+	require.NoError(t, err)
+	nodes = append(nodes, inner)
+
+	for _, node := range nodes {
+		itr, err := tree.SubTreeIterator(node.hash)
+		require.NoError(t, err)
+		keys := []string{}
+		for ; itr.Valid(); itr.Next() {
+			keys = append(keys, string(itr.Key()))
+		}
+		require.NoError(t, itr.Close())
+		require.Equal(t, leaves(node), keys)
+	}
+
+	_, err = tree.SubTreeIterator([]byte("missing"))
+	require.ErrorIs(t, err, ErrNodeNotFound)
+
+	// Nodes of other versions are not part of the tree.
+	_, err = tree.Set([]byte("unsaved"), []byte("changed"))
+	require.NoError(t, err)
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	itree, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+	_, err = itree.SubTreeIterator(tree.root.hash)
+	require.ErrorIs(t, err, ErrNodeNotFound)
+}
+
+func TestIterateInOrder_ImmutableTree(t *testing.T) {
+	tree, mirror := getRandomizedTreeAndMirror(t)
+	mirrorKeys := getSortedMirrorKeys(mirror)
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+
+	immutableTree, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+
+	// Expected depths, from a walk of the whole tree.
+	depths := map[string]int{}
+	var walk func(node *Node, depth int)
+	walk = func(node *Node, depth int) {
+		if node.isLeaf() {
+			depths[string(node.key)] = depth
+			return
+		}
+		left, err := node.getLeftNode(immutableTree)
+		require.NoError(t, err)
+		right, err := node.getRightNode(immutableTree)
+		require.NoError(t, err)
+		walk(left, depth+1)
+		walk(right, depth+1)
+	}
+	walk(immutableTree.root, 0)
+
+	start, end := mirrorKeys[10], mirrorKeys[len(mirrorKeys)-10]
+	keys := []string{}
+	stopped, err := immutableTree.IterateInOrder([]byte(start), []byte(end), func(key, value []byte, depth int) bool {
+		require.Equal(t, mirror[string(key)], string(value))
+		require.Equal(t, depths[string(key)], depth, "key %X", key)
+		keys = append(keys, string(key))
+		return false
+	})
+	require.NoError(t, err)
+	require.False(t, stopped)
+	require.Equal(t, mirrorKeys[10:len(mirrorKeys)-10], keys)
+
+	count := 0
+	stopped, err = immutableTree.IterateInOrder(nil, nil, func(key, value []byte, depth int) bool {
+		count++
+		return count == 3
+	})
+	require.NoError(t, err)
+	require.True(t, stopped)
+	require.Equal(t, 3, count)
+}
+
+func TestPrewarmPaths(t *testing.T) {
+	memDB := db.NewMemDB()
+	tree, err := NewMutableTree(memDB, 0, false)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		_, err := tree.Set([]byte{byte(i)}, []byte{byte(i)})
+		require.NoError(t, err)
+	}
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+
+	reloaded, err := NewMutableTree(memDB, 1000, false)
+	require.NoError(t, err)
+	_, err = reloaded.Load()
+	require.NoError(t, err)
+	cached := reloaded.ndb.nodeCache.Len()
+
+	keys := [][]byte{{90}, {3}, {200}, {42}}
+	require.NoError(t, reloaded.PrewarmPaths(keys))
+
+	// All nodes on the paths are cached, checked before they are loaded again.
+	for _, key := range keys {
+		node := reloaded.root
+		for !node.isLeaf() {
+			childHash := node.rightHash
+			if bytes.Compare(key, node.key) < 0 {
+				childHash = node.leftHash
+			}
+			require.True(t, reloaded.ndb.nodeCache.Has(childHash), "key %X", key)
+			node, err = reloaded.ndb.GetNode(childHash)
+			require.NoError(t, err)
+		}
+	}
+	// But not the whole tree.
+	require.Less(t, reloaded.ndb.nodeCache.Len()-cached, 4*int(reloaded.Height())+1)
+
+	require.NoError(t, reloaded.PrewarmPaths(nil))
+}
+
+func TestGetAndTouch(t *testing.T) {
+	memDB := db.NewMemDB()
+	tree, err := NewMutableTree(memDB, 0, true)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		_, err := tree.Set([]byte{byte(i)}, []byte{byte(i)})
+		require.NoError(t, err)
+	}
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+
+	pathCached := func(tree *MutableTree, key []byte) bool {
+		node := tree.root
+		for !node.isLeaf() {
+			childHash := node.rightHash
+			if bytes.Compare(key, node.key) < 0 {
+				childHash = node.leftHash
+			}
+			if !tree.ndb.nodeCache.Has(childHash) {
+				return false
+			}
+			node, err = tree.ndb.GetNode(childHash)
+			require.NoError(t, err)
+		}
+		return true
+	}
+
+	// Read keys 0 and 99, then 50, with a cache only large enough for the first two paths. Unless
+	// it is touched, the path to key 0 is the least recently used, and evicted.
+	for _, touch := range []bool{false, true} {
+		tree, err := NewMutableTree(memDB, 1000, true)
+		require.NoError(t, err)
+		_, err = tree.Load()
+		require.NoError(t, err)
+		for _, key := range []byte{0, 99} {
+			_, err = tree.Get([]byte{key})
+			require.NoError(t, err)
+		}
+		cacheSize := tree.ndb.nodeCache.Len()
+
+		tree, err = NewMutableTree(memDB, cacheSize, true)
+		require.NoError(t, err)
+		_, err = tree.Load()
+		require.NoError(t, err)
+		for _, key := range []byte{0, 99} {
+			_, err = tree.Get([]byte{key})
+			require.NoError(t, err)
+		}
+		if touch {
+			value, err := tree.GetAndTouch([]byte{0})
+			require.NoError(t, err)
+			require.Equal(t, []byte{0}, value)
+		}
+		_, err = tree.Get([]byte{50})
+		require.NoError(t, err)
+		require.Equal(t, touch, pathCached(tree, []byte{0}))
+	}
+}
+
+func TestIterateOrdered_ImmutableTree(t *testing.T) {
+	tree, mirror := getRandomizedTreeAndMirror(t)
+	mirrorKeys := getSortedMirrorKeys(mirror)
+
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+
+	immutableTree, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+
+	// Request every other key in reverse order, along with keys that don't exist.
+	keys := [][]byte{[]byte("\xff\xff")}
+	expected := []string{}
+	for i := len(mirrorKeys) - 1; i >= 0; i -= 2 {
+		keys = append(keys, []byte(mirrorKeys[i]), []byte(mirrorKeys[i]+"\x00"))
+		expected = append([]string{mirrorKeys[i]}, expected...)
+	}
+
+	actual := []string{}
+	stopped, err := immutableTree.IterateOrdered(keys, func(key, value []byte) bool {
+		require.Equal(t, mirror[string(key)], string(value))
+		actual = append(actual, string(key))
+		return false
+	})
+	require.NoError(t, err)
+	require.False(t, stopped)
+	require.Equal(t, expected, actual)
+	require.Equal(t, []byte("\xff\xff"), keys[0], "input keys must not be reordered")
+
+	count := 0
+	stopped, err = immutableTree.IterateOrdered(keys, func(key, value []byte) bool {
+		count++
+		return count == 2
+	})
+	require.NoError(t, err)
+	require.True(t, stopped)
+	require.Equal(t, 2, count)
+}
+
+func TestMultiHas_ImmutableTree(t *testing.T) {
+	tree, mirror := getRandomizedTreeAndMirror(t)
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+
+	immutableTree, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+
+	keys := [][]byte{}
+	expected := []bool{}
+	for key := range mirror {
+		keys = append(keys, []byte(key), []byte(key+"\x00"), []byte(key))
+		expected = append(expected, true, false, true)
+	}
+	has, err := immutableTree.MultiHas(keys)
+	require.NoError(t, err)
+	require.Equal(t, expected, has)
+
+	has, err = immutableTree.MultiHas(nil)
+	require.NoError(t, err)
+	require.Empty(t, has)
+}
+
 func Benchmark_GetWithIndex(b *testing.B) {
 	db, err := db.NewDB("test", db.MemDBBackend, "")
 	require.NoError(b, err)
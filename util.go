@@ -95,6 +95,20 @@ func cpIncr(bz []byte) (ret []byte) {
 	return []byte{0x00}
 }
 
+// Returns the first key after all keys with the given prefix,
+// or nil if there is none, i.e. if the prefix is empty or all 0xFF.
+func prefixEnd(prefix []byte) []byte {
+	end := cp(prefix)
+	for len(end) > 0 {
+		if end[len(end)-1] < byte(0xFF) {
+			end[len(end)-1]++
+			return end
+		}
+		end = end[:len(end)-1]
+	}
+	return nil
+}
+
 // Colors: ------------------------------------------------
 
 const (